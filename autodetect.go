@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Key the connection settings from the proxy settings UI are stored in,
+// relative to the hive
+const CONNECTIONS_KEY = proxymon.INTERNET_SETTINGS_KEY + `\Connections`
+
+// Binary value holding the settings of the default connection
+const DEFAULT_CONNECTION_SETTINGS_VALUE = "DefaultConnectionSettings"
+
+// Flag in the connection settings that's set when "Automatically detect
+// settings" (WPAD) is turned on
+const CONNECTION_FLAG_AUTO_DETECT uint32 = 0x08
+
+// Reads the flags from a DefaultConnectionSettings blob. The blob starts with
+// three little-endian DWORDs: a version, a change counter and the flags
+func parseConnectionFlags(data []byte) (uint32, error) {
+	if len(data) < 12 {
+		return 0, errors.New("DefaultConnectionSettings value is truncated")
+	}
+
+	return binary.LittleEndian.Uint32(data[8:12]), nil
+}
+
+// Reads whether auto-detect is turned on for the hive. When the value doesn't
+// exist, the settings have never been changed from the UI, in which case
+// it's off
+func readAutoDetect(hive proxymon.Hive) (bool, error) {
+	key, err := proxymon.OpenKey(hive, CONNECTIONS_KEY)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer key.Close()
+
+	data, _, err := key.GetBinaryValue(DEFAULT_CONNECTION_SETTINGS_VALUE)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	flags, err := parseConnectionFlags(data)
+	if err != nil {
+		return false, err
+	}
+
+	return flags&CONNECTION_FLAG_AUTO_DETECT != 0, nil
+}
+
+// Checks the hive's "Automatically detect settings" toggle in a loop and logs
+// when it's turned on or off. The state when the monitor starts is only the
+// baseline, so it isn't logged
+func watchAutoDetect(ctx context.Context, hive proxymon.Hive, prefix string, logFile *monitorLog) {
+	var last bool
+	hasBaseline := false
+
+	// A missing or unreadable key is only reported once, until it can be
+	// read again
+	var failures failureReporter
+
+	for {
+		config := currentConfig()
+
+		if controller.Enabled() {
+			enabled, err := readAutoDetect(hive)
+
+			if err != nil {
+				if failures.shouldReport("", err) {
+					printError(prefix+"Failed to read auto-detect setting:", err)
+				}
+			} else {
+				failures.clear("")
+
+				if hasBaseline && enabled != last && config.logsEvent(EVENT_PAC_CHANGE) {
+					message := prefix + "auto-detect (WPAD) disabled"
+					if enabled {
+						message = prefix + "auto-detect (WPAD) enabled"
+					}
+
+					writeLogEntry(logFile, message)
+					writeSeverityEvent(config, SEVERITY_INFO, message)
+					printEvent(EVENT_PAC_CHANGE, time.Now().Format(config.timestampLayout())+" "+message)
+				}
+
+				last = enabled
+				hasBaseline = true
+			}
+		}
+
+		if !sleepContext(ctx, config.pollInterval()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package main
+
+import (
+	"testing"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+func TestReadAutoDetect(t *testing.T) {
+	hive := proxymon.HIVE_HKCU
+	defer proxymon.DeleteFakeValue(hive, CONNECTIONS_KEY, DEFAULT_CONNECTION_SETTINGS_VALUE)
+
+	// Never changed from the UI, so the value doesn't exist yet
+	enabled, err := readAutoDetect(hive)
+	if err != nil || enabled {
+		t.Fatalf("readAutoDetect without the value = %t, %v, want false, nil", enabled, err)
+	}
+
+	proxymon.SetFakeValue(hive, CONNECTIONS_KEY, DEFAULT_CONNECTION_SETTINGS_VALUE, connectionSettingsBlob(0x46, 1, 0x09))
+	enabled, err = readAutoDetect(hive)
+	if err != nil || !enabled {
+		t.Errorf("readAutoDetect with 0x09 = %t, %v, want true, nil", enabled, err)
+	}
+
+	proxymon.SetFakeValue(hive, CONNECTIONS_KEY, DEFAULT_CONNECTION_SETTINGS_VALUE, connectionSettingsBlob(0x46, 2, 0x01))
+	enabled, err = readAutoDetect(hive)
+	if err != nil || enabled {
+		t.Errorf("readAutoDetect with 0x01 = %t, %v, want false, nil", enabled, err)
+	}
+
+	proxymon.SetFakeValue(hive, CONNECTIONS_KEY, DEFAULT_CONNECTION_SETTINGS_VALUE, []byte{0x46, 0x00})
+	_, err = readAutoDetect(hive)
+	if err == nil {
+		t.Error("readAutoDetect of a truncated blob didn't fail")
+	}
+}
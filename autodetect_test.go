@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Builds the start of a DefaultConnectionSettings blob, the version, change
+// counter and flags DWORDs, followed by the rest of the blob
+func connectionSettingsBlob(version uint32, counter uint32, flags uint32, rest ...byte) []byte {
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint32(data[0:4], version)
+	binary.LittleEndian.PutUint32(data[4:8], counter)
+	binary.LittleEndian.PutUint32(data[8:12], flags)
+
+	return append(data, rest...)
+}
+
+func TestParseConnectionFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		want       uint32
+		autoDetect bool
+	}{
+		{name: "direct only", data: connectionSettingsBlob(0x46, 1, 0x01), want: 0x01, autoDetect: false},
+		{name: "auto-detect on", data: connectionSettingsBlob(0x46, 2, 0x09), want: 0x09, autoDetect: true},
+		{name: "proxy and auto-detect", data: connectionSettingsBlob(0x46, 3, 0x0b), want: 0x0b, autoDetect: true},
+		{name: "proxy without auto-detect", data: connectionSettingsBlob(0x46, 4, 0x03), want: 0x03, autoDetect: false},
+		{name: "every flag", data: connectionSettingsBlob(0x46, 5, 0x0f), want: 0x0f, autoDetect: true},
+		{name: "only the header", data: connectionSettingsBlob(0x3c, 0, 0x08), want: 0x08, autoDetect: true},
+		{
+			name:       "followed by the proxy server",
+			data:       connectionSettingsBlob(0x46, 6, 0x09, 0x05, 0x00, 0x00, 0x00, 'a', ':', '8', '0', 0x00),
+			want:       0x09,
+			autoDetect: true,
+		},
+		{
+			name:       "flags in the high bytes are kept",
+			data:       connectionSettingsBlob(0x46, 7, 0x0100_0001),
+			want:       0x0100_0001,
+			autoDetect: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseConnectionFlags(test.data)
+			if err != nil {
+				t.Fatalf("parseConnectionFlags failed: %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("parseConnectionFlags = %#x, want %#x", got, test.want)
+			}
+
+			autoDetect := got&CONNECTION_FLAG_AUTO_DETECT != 0
+			if autoDetect != test.autoDetect {
+				t.Errorf("auto-detect = %t, want %t", autoDetect, test.autoDetect)
+			}
+		})
+	}
+}
+
+func TestParseConnectionFlagsTruncated(t *testing.T) {
+	for _, length := range []int{0, 1, 8, 11} {
+		_, err := parseConnectionFlags(make([]byte, length))
+		if err == nil {
+			t.Errorf("parseConnectionFlags of a %d byte blob didn't fail", length)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Added to the name of a compressed log file, so proxy-monitor-2024-06-01.log
+// becomes proxy-monitor-2024-06-01.log.gz
+const COMPRESSED_LOG_EXT = ".gz"
+
+// Added to the compressed file while it's being written. It's only renamed
+// once it's complete, so a .gz file is never half written
+const COMPRESSING_LOG_EXT = ".tmp"
+
+// Files currently being compressed, so the same file is never compressed by
+// two goroutines at once
+var compressingLogs = map[string]bool{}
+var compressingLogsMutex sync.Mutex
+
+// Compresses a log file that's no longer written to in the background, so it
+// never holds up the writer. Shutting down waits for it to finish
+func compressLogInBackground(path string) {
+	compressingLogsMutex.Lock()
+	defer compressingLogsMutex.Unlock()
+
+	if compressingLogs[path] {
+		return
+	}
+	compressingLogs[path] = true
+
+	startWorker(func() {
+		err := compressLogFile(path)
+		if err != nil {
+			printError("Failed to compress old log file:", err)
+		}
+
+		compressingLogsMutex.Lock()
+		delete(compressingLogs, path)
+		compressingLogsMutex.Unlock()
+	})
+}
+
+// Gzips the file next to itself and removes the original. The original is
+// only removed once the compressed file has been written, synced and renamed
+// into place, so if the monitor dies halfway, the original is still there and
+// gets compressed again on the next start
+func compressLogFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target := path + COMPRESSED_LOG_EXT
+	temporary := target + COMPRESSING_LOG_EXT
+
+	file, err := os.Create(temporary)
+	if err != nil {
+		return err
+	}
+
+	// A nested function that writes the compressed data and makes sure it's
+	// on disk before the file is closed
+	var write = func() error {
+		w := gzip.NewWriter(file)
+		w.Name = filepath.Base(path)
+
+		_, err := io.Copy(w, source)
+		if err != nil {
+			return err
+		}
+
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+
+		return file.Sync()
+	}
+
+	err = write()
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	err = os.Rename(temporary, target)
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+
+	source.Close()
+	return os.Remove(path)
+}
+
+// Compresses the daily log files from before today that are still
+// uncompressed, like the ones from when the monitor wasn't running at
+// midnight, or one whose compression was cut short
+func compressOldDailyLogs(logPath string, now time.Time) {
+	ext := filepath.Ext(logPath)
+	prefix := filepath.Base(strings.TrimSuffix(logPath, ext)) + "-"
+	today := now.Format(LOG_DATE_FORMAT)
+
+	entries, err := os.ReadDir(filepath.Dir(logPath))
+	if err != nil {
+		printError("Failed to list old log files:", err)
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		// Skip files that just happen to start with the same name
+		date := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		_, err := time.ParseInLocation(LOG_DATE_FORMAT, date, time.Local)
+		if err != nil || date >= today {
+			continue
+		}
+
+		compressLogInBackground(filepath.Join(filepath.Dir(logPath), name))
+	}
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Name of the config file, stored in the program's data directory
+const CONFIG_FILE = "config.json"
+
+// File access permissions of the config file: only we can read/write it,
+// since it can hold the http_token and a webhook URL with a token in it
+const CONFIG_FILE_PERMISSIONS os.FileMode = 0600
+
+// Name of the log file, when its path isn't set in the config
+const LOG_FILE = "proxy-monitor.log"
+
+// Environment variable that overrides the directory the log file is kept in
+const LOG_DIR_ENV = "PROXY_MONITOR_LOG_DIR"
+
+// Default values used when the config file leaves a setting out or sets it to
+// something invalid
+const DEFAULT_POLL_INTERVAL_MS = 1000
+const DEFAULT_REGISTRY_HIVE = "HKCU"
+
+// registry_hive value that watches every loaded user profile under HKEY_USERS
+const REGISTRY_HIVE_USERS = "USERS"
+const DEFAULT_DEBOUNCE_MS = 500
+const DEFAULT_TIMESTAMP_FORMAT = "ansic"
+const DEFAULT_POLL_INTERVAL_MIN_MS = 250
+const DEFAULT_POLL_INTERVAL_MAX_MS = 5000
+const DEFAULT_POLL_JITTER_PERCENT = 10
+
+// Highest poll_jitter_percent, more would let an interval drop to nothing
+const MAX_POLL_JITTER_PERCENT = 50
+const DEFAULT_NOTIFICATION_INTERVAL_MS = 10000
+
+// Named timestamp formats that can be used in place of a Go layout string
+var TIMESTAMP_PRESETS = map[string]string{
+	"ansic":       time.ANSIC,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"iso8601":     time.RFC3339,
+}
+
+// Settings loaded from the config file
+type Config struct {
+	// How often the registry is checked for changes, in milliseconds
+	PollIntervalMs int `json:"poll_interval_ms"`
+
+	// Whether to poll quickly right after a change and slow down gradually
+	// while nothing changes, instead of using poll_interval_ms
+	AdaptivePoll bool `json:"adaptive_poll"`
+
+	// Fastest and slowest poll intervals used by adaptive polling, in
+	// milliseconds
+	PollIntervalMinMs int `json:"poll_interval_min_ms"`
+	PollIntervalMaxMs int `json:"poll_interval_max_ms"`
+
+	// How far every poll interval is randomly stretched or shortened, in
+	// percent, so many machines don't all poll at the same moment
+	PollJitterPercent int `json:"poll_jitter_percent"`
+
+	// Path of the file proxy changes are logged to
+	LogPath string `json:"log_path"`
+
+	// How the log file is rotated, none or daily. With daily rotation the
+	// date is added to the log file's name
+	Rotation string `json:"rotation"`
+
+	// How many days of daily log files are kept, 0 to keep all of them
+	MaxDays int `json:"max_days"`
+
+	// Whether to gzip daily log files once a new day has started
+	CompressRotated bool `json:"compress_rotated"`
+
+	// Which registry hive's Internet Settings to monitor: HKCU, HKLM, BOTH or
+	// USERS
+	RegistryHive string `json:"registry_hive"`
+
+	// Whether to flush the log file to the disk after every change
+	SyncLog bool `json:"sync_log"`
+
+	// How long the proxy settings have to stay the same before a change is
+	// logged, in milliseconds. 0 logs every change right away
+	DebounceMs int `json:"debounce_ms"`
+
+	// How long new proxy settings have to last before they count as a
+	// change, in milliseconds. Shorter flickers are never logged. 0 counts
+	// every change
+	MinStableDurationMs int `json:"min_stable_duration_ms"`
+
+	// How long to wait after starting before the baseline is read, in
+	// milliseconds, so the settings can settle while the machine boots. 0
+	// starts right away
+	StartupDelayMs int `json:"startup_delay_ms"`
+
+	// Whether to show a desktop notification when the proxy is turned on or off
+	Notifications bool `json:"notifications"`
+
+	// Least time between two notifications, in milliseconds. The changes in
+	// between are summed up in one notification afterwards. 0 shows every one
+	NotificationIntervalMs int `json:"notification_interval_ms"`
+
+	// Whether to stop logging while the session is locked, resuming once
+	// it's unlocked
+	PauseWhenLocked bool `json:"pause_when_locked"`
+
+	// Whether to show the tray icon, turned off for headless runs
+	Tray bool `json:"tray"`
+
+	// Whether to also write proxy changes to the Windows Event Log
+	EventLog bool `json:"event_log"`
+
+	// Whether to print every registry poll, for debugging why a change
+	// wasn't detected
+	Verbose bool `json:"verbose"`
+
+	// How log lines are timestamped, one of the presets like rfc3339, or a
+	// Go time layout string
+	TimestampFormat string `json:"timestamp_format"`
+
+	// Whether log timestamps are in UTC instead of the local time zone
+	TimestampUTC bool `json:"timestamp_utc"`
+
+	// Whether to number every log line, so gaps from lost lines stand out.
+	// Numbers start over when the monitor is restarted
+	LogSequence bool `json:"log_sequence"`
+
+	// Whether to start the local HTTP status and control server
+	HTTPEnabled bool `json:"http_enabled"`
+
+	// Address the HTTP server listens on, loopback only by default
+	HTTPAddr string `json:"http_addr"`
+
+	// Secret control requests to the HTTP server have to send. Required for
+	// the control endpoints when http_addr isn't a loopback address
+	HTTPToken string `json:"http_token"`
+
+	// Whether to also watch the machine-wide WinHTTP proxy, which services
+	// use instead of the Internet Settings
+	WinHTTPProxy bool `json:"winhttp_proxy"`
+
+	// URL every proxy change is posted to as JSON, empty to not send any
+	WebhookURL string `json:"webhook_url"`
+
+	// Syslog server every proxy change is also sent to, like udp://siem:514
+	// or tcp://siem:514. Empty to not send any
+	SyslogAddr string `json:"syslog_addr"`
+
+	// Other registry values to log changes of, next to the proxy settings
+	WatchedValues []WatchedValue `json:"watched_values"`
+
+	// Registry values read whenever the proxy changes and attached to the
+	// log line and webhook, like ProxyHttp1.1 or SecureProtocols
+	ContextValues []WatchedValue `json:"context_values"`
+
+	// Whether to end every proxy change line with where the change came from,
+	// the monitor itself or something else
+	LogChangeOrigin bool `json:"log_change_origin"`
+
+	// Whether to also log when the contents of a local file:// PAC script
+	// change, which doesn't show up in the registry
+	WatchPacFile bool `json:"watch_pac_file"`
+
+	// Least severity a change needs to show a notification, post to the
+	// webhook or be written to the Event Log: info or notable
+	NotificationSeverity string `json:"notification_severity"`
+	WebhookSeverity      string `json:"webhook_severity"`
+	EventLogSeverity     string `json:"event_log_severity"`
+
+	// Which kinds of proxy changes are written to the log, like enable,
+	// disable and server_change. Changes are still tracked when left out
+	LogEvents []string `json:"log_events"`
+
+	// Known-good proxy servers. When the proxy is pointed anywhere else, a
+	// warning is logged. Empty expects every server
+	ExpectedProxies []string `json:"expected_proxies"`
+
+	// Whether to also show a notification for an unexpected proxy server,
+	// even when other notifications are turned off or held back
+	AlertUnexpectedProxy bool `json:"alert_unexpected_proxy"`
+
+	// Whether -enable-proxy and -disable-proxy may change the proxy settings
+	// in the registry. Off by default, the monitor only watches otherwise
+	AllowWrites bool `json:"allow_writes"`
+}
+
+// Returns the config with every setting at its default value. If the data
+// directory can't be found, the log path is left empty, so starting the
+// monitor fails with a clear error instead of logging to the working directory
+func defaultConfig() Config {
+	logPath := ""
+	dataDir, err := getDataDir()
+	if err == nil {
+		logPath = filepath.Join(dataDir, LOG_FILE)
+	}
+
+	return Config{
+		PollIntervalMs:    DEFAULT_POLL_INTERVAL_MS,
+		PollIntervalMinMs: DEFAULT_POLL_INTERVAL_MIN_MS,
+		PollIntervalMaxMs: DEFAULT_POLL_INTERVAL_MAX_MS,
+		PollJitterPercent: DEFAULT_POLL_JITTER_PERCENT,
+		LogPath:           logPath,
+		Rotation:          ROTATION_NONE,
+		RegistryHive:      DEFAULT_REGISTRY_HIVE,
+		SyncLog:           true,
+		DebounceMs:        DEFAULT_DEBOUNCE_MS,
+		Notifications:     true,
+		Tray:              true,
+		HTTPAddr:          DEFAULT_HTTP_ADDR,
+		TimestampFormat:   DEFAULT_TIMESTAMP_FORMAT,
+		WatchedValues:     []WatchedValue{},
+		ContextValues:     []WatchedValue{},
+		LogEvents:         append([]string{}, ALL_LOG_EVENTS...),
+		ExpectedProxies:   []string{},
+
+		AlertUnexpectedProxy: true,
+
+		NotificationIntervalMs: DEFAULT_NOTIFICATION_INTERVAL_MS,
+		NotificationSeverity:   SEVERITY_NOTABLE,
+		WebhookSeverity:        SEVERITY_INFO,
+		EventLogSeverity:       SEVERITY_INFO,
+	}
+}
+
+// Directory the config and log files are kept in. That's %APPDATA% on
+// Windows, and the user's config directory elsewhere. If APPDATA isn't set,
+// the Roaming folder is found through the user profile instead
+func getDataDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err == nil {
+		return filepath.Join(configDir, "proxy-monitor"), nil
+	}
+
+	userProfile := os.Getenv("USERPROFILE")
+	if userProfile != "" {
+		return filepath.Join(userProfile, "AppData", "Roaming", "proxy-monitor"), nil
+	}
+
+	return "", fmt.Errorf("failed to find the data directory, neither APPDATA nor USERPROFILE is set: %w", err)
+}
+
+// Loads the config file from the data directory. If the file doesn't exist
+// yet, it's created with the default values. Any setting that's missing or
+// invalid falls back to its default, so a broken config never stops the
+// monitor from starting
+func loadConfig() Config {
+	config := defaultConfig()
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		printWarning("Not loading the config file:", err)
+		return applyEnvOverrides(config)
+	}
+
+	configPath := configFilePath(dataDir)
+
+	loaded, problems, err := readConfigFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		err = writeConfig(configPath, config)
+		if err != nil {
+			printError("Failed to create config file:", err)
+		}
+
+		return applyEnvOverrides(config)
+	}
+
+	if err != nil {
+		printError("Failed to load config file, using defaults:", err)
+		return applyEnvOverrides(config)
+	}
+
+	printConfigProblems(problems)
+	return applyEnvOverrides(loaded)
+}
+
+// Reads and validates the config file, in any of the supported formats.
+// Settings that aren't in the file keep their default values, invalid ones
+// are reset to them and returned as problems
+func readConfigFile(configPath string) (Config, []configProblem, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	data, err = configToJSON(configPath, data)
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	loaded := defaultConfig()
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	loaded, problems := validateConfig(loaded)
+	return loaded, problems, nil
+}
+
+// The config the running monitor uses, replaced when it's reloaded
+var activeConfig = defaultConfig()
+var activeConfigMutex sync.Mutex
+
+// Returns the config the running monitor uses
+func currentConfig() Config {
+	activeConfigMutex.Lock()
+	defer activeConfigMutex.Unlock()
+
+	return activeConfig
+}
+
+// Makes the config the one the running monitor uses
+func setCurrentConfig(config Config) {
+	activeConfigMutex.Lock()
+	defer activeConfigMutex.Unlock()
+
+	activeConfig = config
+}
+
+// Re-reads the config file and applies it to the running monitor. If the
+// file can't be read, has any invalid setting or the new log file can't be
+// opened, the old config is kept. Settings that decide what's being watched
+// only take effect after a restart
+func reloadConfig() error {
+	dataDir, err := getDataDir()
+	if err != nil {
+		return err
+	}
+
+	configPath := configFilePath(dataDir)
+
+	loaded, problems, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Unlike at startup, there's a working config to fall back to, so a
+	// typo doesn't quietly reset a setting to its default
+	err = configProblemsError(problems)
+	if err != nil {
+		return fmt.Errorf("invalid config, keeping the old one: %w", err)
+	}
+
+	loaded = applyEnvOverrides(loaded)
+	loaded.Verbose = loaded.Verbose || verboseOption
+	loaded.WatchPacFile = loaded.WatchPacFile || watchFileOption
+
+	err = validateLogPath(loaded.logFilePath(time.Now()))
+	if err != nil {
+		return fmt.Errorf("log file %s is not writable: %w", loaded.LogPath, err)
+	}
+
+	logMutex.Lock()
+	logFile := activeLog
+	logMutex.Unlock()
+
+	if logFile != nil {
+		err = logFile.apply(loaded)
+		if err != nil {
+			return err
+		}
+	}
+
+	old := currentConfig()
+	if loaded.RegistryHive != old.RegistryHive || loaded.HTTPEnabled != old.HTTPEnabled || loaded.HTTPAddr != old.HTTPAddr ||
+		loaded.EventLog != old.EventLog || loaded.WinHTTPProxy != old.WinHTTPProxy ||
+		loaded.Tray != old.Tray || fmt.Sprint(loaded.WatchedValues) != fmt.Sprint(old.WatchedValues) {
+		printWarning("registry_hive, http_enabled, http_addr, event_log, winhttp_proxy, tray and watched_values only change after a restart")
+	}
+
+	setCurrentConfig(loaded)
+	printInfo("Reloaded config from", configPath)
+	return nil
+}
+
+// Applies settings from environment variables, which take precedence over
+// the config file
+func applyEnvOverrides(config Config) Config {
+	logDir := os.Getenv(LOG_DIR_ENV)
+	if logDir != "" {
+		config.LogPath = filepath.Join(logDir, LOG_FILE)
+	}
+
+	return config
+}
+
+// An invalid setting in the config, and what was used instead
+type configProblem struct {
+	problem  string
+	fallback string
+}
+
+func (p configProblem) String() string {
+	return p.problem + ", " + p.fallback
+}
+
+// Prints every problem with the config as a warning
+func printConfigProblems(problems []configProblem) {
+	for _, problem := range problems {
+		printWarning(problem.String())
+	}
+}
+
+// Turns the problems with the config into a single error, nil if there
+// weren't any
+func configProblemsError(problems []configProblem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.problem)
+	}
+
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// Resets every invalid setting in the config back to its default value.
+// Returns what was wrong with each setting that was reset
+func validateConfig(config Config) (Config, []configProblem) {
+	defaults := defaultConfig()
+	problems := []configProblem{}
+
+	// A nested function that records an invalid setting
+	var invalid = func(fallback string, format string, args ...any) {
+		problems = append(problems, configProblem{problem: fmt.Sprintf(format, args...), fallback: fallback})
+	}
+
+	if config.PollIntervalMs <= 0 {
+		invalid("using default", "Invalid poll_interval_ms in config: %d", config.PollIntervalMs)
+		config.PollIntervalMs = defaults.PollIntervalMs
+	}
+
+	if config.PollIntervalMinMs <= 0 {
+		invalid("using default", "Invalid poll_interval_min_ms in config: %d", config.PollIntervalMinMs)
+		config.PollIntervalMinMs = defaults.PollIntervalMinMs
+	}
+
+	if config.PollIntervalMaxMs < config.PollIntervalMinMs {
+		invalid("using the minimum", "poll_interval_max_ms in config is less than poll_interval_min_ms: %d", config.PollIntervalMaxMs)
+		config.PollIntervalMaxMs = config.PollIntervalMinMs
+	}
+
+	if config.PollJitterPercent < 0 || config.PollJitterPercent > MAX_POLL_JITTER_PERCENT {
+		invalid("using default", "Invalid poll_jitter_percent in config, expected 0 to %d: %d", MAX_POLL_JITTER_PERCENT, config.PollJitterPercent)
+		config.PollJitterPercent = defaults.PollJitterPercent
+	}
+
+	if config.DebounceMs < 0 {
+		invalid("using default", "Invalid debounce_ms in config: %d", config.DebounceMs)
+		config.DebounceMs = defaults.DebounceMs
+	}
+
+	if config.NotificationIntervalMs < 0 {
+		invalid("using default", "Invalid notification_interval_ms in config: %d", config.NotificationIntervalMs)
+		config.NotificationIntervalMs = defaults.NotificationIntervalMs
+	}
+
+	if config.MinStableDurationMs < 0 {
+		invalid("not ignoring flickers", "Invalid min_stable_duration_ms in config: %d", config.MinStableDurationMs)
+		config.MinStableDurationMs = 0
+	}
+
+	if config.StartupDelayMs < 0 {
+		invalid("starting right away", "Invalid startup_delay_ms in config: %d", config.StartupDelayMs)
+		config.StartupDelayMs = 0
+	}
+
+	if config.LogPath == "" {
+		config.LogPath = defaults.LogPath
+	}
+
+	if config.Rotation != ROTATION_NONE && config.Rotation != ROTATION_DAILY {
+		invalid("using default", "Invalid rotation in config: %s", config.Rotation)
+		config.Rotation = defaults.Rotation
+	}
+
+	if config.MaxDays < 0 {
+		invalid("keeping every log file", "Invalid max_days in config: %d", config.MaxDays)
+		config.MaxDays = 0
+	}
+
+	_, err := parseRegistryHives(config.RegistryHive)
+	if err != nil {
+		invalid("using default", "Invalid registry_hive in config: %v", err)
+		config.RegistryHive = defaults.RegistryHive
+	}
+
+	_, _, err = net.SplitHostPort(config.HTTPAddr)
+	if err != nil {
+		invalid("using default", "Invalid http_addr in config: %v", err)
+		config.HTTPAddr = defaults.HTTPAddr
+	}
+
+	_, err = parseTimestampFormat(config.TimestampFormat)
+	if err != nil {
+		invalid("using default", "Invalid timestamp_format in config: %v", err)
+		config.TimestampFormat = defaults.TimestampFormat
+	}
+
+	if config.WebhookURL != "" {
+		err := validateWebhookURL(config.WebhookURL)
+		if err != nil {
+			invalid("not sending webhooks", "Invalid webhook_url in config: %v", err)
+			config.WebhookURL = ""
+		}
+	}
+
+	if config.SyslogAddr != "" {
+		addr, err := validateSyslogAddr(config.SyslogAddr)
+		if err != nil {
+			invalid("not sending to syslog", "Invalid syslog_addr in config: %v", err)
+		}
+		config.SyslogAddr = addr
+	}
+
+	var watchedProblems, contextProblems []configProblem
+	config.WatchedValues, watchedProblems = validateWatchedValues(config.WatchedValues, "watched value")
+	config.ContextValues, contextProblems = validateWatchedValues(config.ContextValues, "context value")
+	problems = append(problems, watchedProblems...)
+	problems = append(problems, contextProblems...)
+
+	// Leaving it out logs everything, an empty list logs nothing
+	if config.LogEvents == nil {
+		config.LogEvents = defaults.LogEvents
+	}
+
+	err = validateLogEvents(config.LogEvents)
+	if err != nil {
+		invalid("using default", "Invalid log_events in config: %v", err)
+		config.LogEvents = defaults.LogEvents
+	}
+
+	if config.ExpectedProxies == nil {
+		config.ExpectedProxies = defaults.ExpectedProxies
+	}
+	config.ExpectedProxies = normalizeExpectedProxies(config.ExpectedProxies)
+
+	err = validateSeverity(config.NotificationSeverity)
+	if err != nil {
+		invalid("using default", "Invalid notification_severity in config: %v", err)
+		config.NotificationSeverity = defaults.NotificationSeverity
+	}
+
+	err = validateSeverity(config.WebhookSeverity)
+	if err != nil {
+		invalid("using default", "Invalid webhook_severity in config: %v", err)
+		config.WebhookSeverity = defaults.WebhookSeverity
+	}
+
+	err = validateSeverity(config.EventLogSeverity)
+	if err != nil {
+		invalid("using default", "Invalid event_log_severity in config: %v", err)
+		config.EventLogSeverity = defaults.EventLogSeverity
+	}
+
+	return config, problems
+}
+
+// Removes the invalid registry values from a list in the config. Invalid
+// values are skipped, the rest are still used
+func validateWatchedValues(values []WatchedValue, kind string) ([]WatchedValue, []configProblem) {
+	valid := []WatchedValue{}
+	problems := []configProblem{}
+
+	for _, watched := range values {
+		err := watched.validate()
+		if err != nil {
+			problems = append(problems, configProblem{problem: fmt.Sprintf("Invalid %s %s in config: %v", kind, watched, err), fallback: "skipping"})
+			continue
+		}
+
+		valid = append(valid, watched)
+	}
+
+	return valid, problems
+}
+
+// Writes the config to the given path as indented JSON
+func writeConfig(path string, config Config) error {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, CONFIG_FILE_PERMISSIONS)
+}
+
+// Converts a hive name from the config into the list of hives to monitor.
+// "BOTH" monitors the current user's and the machine-wide settings, "USERS"
+// every user profile that's loaded right now
+func parseRegistryHives(name string) ([]proxymon.Hive, error) {
+	switch strings.ToUpper(name) {
+	case "HKCU", "HKEY_CURRENT_USER":
+		return []proxymon.Hive{proxymon.HIVE_HKCU}, nil
+	case "HKLM", "HKEY_LOCAL_MACHINE":
+		return []proxymon.Hive{proxymon.HIVE_HKLM}, nil
+	case "BOTH":
+		return []proxymon.Hive{proxymon.HIVE_HKCU, proxymon.HIVE_HKLM}, nil
+	case REGISTRY_HIVE_USERS, "HKU", "HKEY_USERS":
+		hives, err := proxymon.LoadedUserHives()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the loaded user profiles: %w", err)
+		}
+
+		return hives, nil
+	default:
+		return nil, fmt.Errorf("unknown registry hive: %s", name)
+	}
+}
+
+// Name of a hive for log lines. User profiles are shown by the user's name,
+// like CORP\alice, falling back to the SID if it can't be looked up
+func hiveDisplayName(hive proxymon.Hive) string {
+	sid, ok := hive.UserSID()
+	if !ok {
+		return string(hive)
+	}
+
+	name, err := lookupAccountName(sid)
+	if err != nil {
+		return sid
+	}
+
+	return name
+}
+
+// Path of the file that's logged to at the given time. With daily rotation,
+// that's the log path with the date added to it
+func (c Config) logFilePath(now time.Time) string {
+	if c.Rotation == ROTATION_DAILY && c.LogPath != "" {
+		return datedLogPath(c.LogPath, now)
+	}
+
+	return c.LogPath
+}
+
+// Returns the poll interval as a duration
+func (c Config) pollInterval() time.Duration {
+	return time.Duration(c.PollIntervalMs) * time.Millisecond
+}
+
+// Randomly stretches or shortens the interval by up to poll_jitter_percent.
+// The global generator is seeded randomly for every process, so machines
+// started at the same time still drift apart
+func (c Config) withJitter(interval time.Duration) time.Duration {
+	spread := int64(interval) * int64(c.PollJitterPercent) / 100
+	if spread <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+// Converts a timestamp format from the config into a Go time layout. Layouts
+// without any of the layout elements would print the same text for every
+// line, so those are treated as typos
+func parseTimestampFormat(format string) (string, error) {
+	layout, ok := TIMESTAMP_PRESETS[strings.ToLower(format)]
+	if ok {
+		return layout, nil
+	}
+
+	if format == "" || time.Now().Format(format) == format {
+		return "", fmt.Errorf("not a preset or time layout: %q", format)
+	}
+
+	return format, nil
+}
+
+// Returns the timestamp format as a Go time layout
+func (c Config) timestampLayout() string {
+	// Validated when the config was loaded
+	layout, _ := parseTimestampFormat(c.TimestampFormat)
+	return layout
+}
+
+// Returns how long to wait before the next poll. With adaptive polling, a
+// change drops the interval to the minimum and every poll without one doubles
+// it, up to the maximum. While monitoring is stopped there's nothing to catch,
+// so the maximum is used
+func (c Config) nextPollInterval(current time.Duration, changed bool) time.Duration {
+	if !c.AdaptivePoll {
+		return c.pollInterval()
+	}
+
+	minInterval := time.Duration(c.PollIntervalMinMs) * time.Millisecond
+	maxInterval := time.Duration(c.PollIntervalMaxMs) * time.Millisecond
+
+	switch {
+	case !controller.Enabled():
+		return maxInterval
+	case changed:
+		return minInterval
+	default:
+		return max(minInterval, min(current*2, maxInterval))
+	}
+}
+
+// Returns the debounce window as a duration
+func (c Config) debounceWindow() time.Duration {
+	return time.Duration(c.DebounceMs) * time.Millisecond
+}
+
+// Returns the least time between two notifications as a duration
+func (c Config) notificationInterval() time.Duration {
+	return time.Duration(c.NotificationIntervalMs) * time.Millisecond
+}
+
+// Returns how long new settings have to last as a duration
+func (c Config) minStableDuration() time.Duration {
+	return time.Duration(c.MinStableDurationMs) * time.Millisecond
+}
+
+// Returns how long to wait before monitoring as a duration
+func (c Config) startupDelay() time.Duration {
+	return time.Duration(c.StartupDelayMs) * time.Millisecond
+}
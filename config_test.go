@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Clears every variable os.UserConfigDir looks at, on any platform
+func clearConfigDirEnv(t *testing.T) {
+	t.Setenv("APPDATA", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "")
+	t.Setenv("USERPROFILE", "")
+}
+
+func TestGetDataDir(t *testing.T) {
+	clearConfigDirEnv(t)
+
+	configDir := filepath.Join(t.TempDir(), "config")
+	if runtime.GOOS == "windows" {
+		t.Setenv("APPDATA", configDir)
+	} else {
+		t.Setenv("XDG_CONFIG_HOME", configDir)
+	}
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		t.Fatalf("getDataDir failed: %v", err)
+	}
+
+	want := filepath.Join(configDir, "proxy-monitor")
+	if dataDir != want {
+		t.Errorf("getDataDir = %q, want %q", dataDir, want)
+	}
+}
+
+func TestGetDataDirUserProfileFallback(t *testing.T) {
+	clearConfigDirEnv(t)
+
+	userProfile := t.TempDir()
+	t.Setenv("USERPROFILE", userProfile)
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		t.Fatalf("getDataDir failed: %v", err)
+	}
+
+	want := filepath.Join(userProfile, "AppData", "Roaming", "proxy-monitor")
+	if dataDir != want {
+		t.Errorf("getDataDir = %q, want %q", dataDir, want)
+	}
+}
+
+func TestGetDataDirEmptyEnv(t *testing.T) {
+	clearConfigDirEnv(t)
+
+	dataDir, err := getDataDir()
+	if err == nil {
+		t.Fatalf("getDataDir = %q, want an error", dataDir)
+	}
+
+	// Never a relative directory in whatever the working directory is
+	if dataDir != "" {
+		t.Errorf("getDataDir returned %q along with the error", dataDir)
+	}
+
+	if !strings.Contains(err.Error(), "APPDATA") || !strings.Contains(err.Error(), "USERPROFILE") {
+		t.Errorf("getDataDir error = %q, want it to name APPDATA and USERPROFILE", err)
+	}
+}
+
+// The same settings in every supported config format
+var EQUIVALENT_CONFIGS = map[string]string{
+	CONFIG_FILE: `{
+	"poll_interval_ms": 2000,
+	"adaptive_poll": true,
+	"log_path": "/var/log/proxy-monitor/proxy_log.txt",
+	"rotation": "daily",
+	"max_days": 14,
+	"registry_hive": "BOTH",
+	"sync_log": false,
+	"debounce_ms": 0,
+	"notifications": false,
+	"http_enabled": true,
+	"http_addr": "127.0.0.1:9090",
+	"timestamp_format": "rfc3339",
+	"log_events": ["enable", "disable"],
+	"expected_proxies": ["10.0.0.1:8080", "proxy.corp:3128"],
+	"watched_values": [
+		{"hive": "HKCU", "key_path": "Software\\Example", "value_name": "Mode", "type": "dword"}
+	]
+}`,
+	"config.toml": `poll_interval_ms = 2000
+adaptive_poll = true
+log_path = "/var/log/proxy-monitor/proxy_log.txt"
+rotation = "daily"
+max_days = 14
+registry_hive = "BOTH"
+sync_log = false
+debounce_ms = 0
+notifications = false
+http_enabled = true
+http_addr = "127.0.0.1:9090"
+timestamp_format = "rfc3339"
+log_events = ["enable", "disable"]
+expected_proxies = ["10.0.0.1:8080", "proxy.corp:3128"]
+
+[[watched_values]]
+hive = "HKCU"
+key_path = 'Software\Example'
+value_name = "Mode"
+type = "dword"
+`,
+	"config.yaml": `poll_interval_ms: 2000
+adaptive_poll: true
+log_path: /var/log/proxy-monitor/proxy_log.txt
+rotation: daily
+max_days: 14
+registry_hive: BOTH
+sync_log: false
+debounce_ms: 0
+notifications: false
+http_enabled: true
+http_addr: "127.0.0.1:9090"
+timestamp_format: rfc3339
+log_events: [enable, disable]
+expected_proxies:
+  - "10.0.0.1:8080"
+  - "proxy.corp:3128"
+watched_values:
+  - hive: HKCU
+    key_path: 'Software\Example'
+    value_name: Mode
+    type: dword
+`,
+}
+
+func TestReadConfigFileFormats(t *testing.T) {
+	want := defaultConfig()
+	want.PollIntervalMs = 2000
+	want.AdaptivePoll = true
+	want.LogPath = "/var/log/proxy-monitor/proxy_log.txt"
+	want.Rotation = ROTATION_DAILY
+	want.MaxDays = 14
+	want.RegistryHive = "BOTH"
+	want.SyncLog = false
+	want.DebounceMs = 0
+	want.Notifications = false
+	want.HTTPEnabled = true
+	want.HTTPAddr = "127.0.0.1:9090"
+	want.TimestampFormat = "rfc3339"
+	want.LogEvents = []string{EVENT_ENABLE, EVENT_DISABLE}
+	want.ExpectedProxies = []string{"10.0.0.1:8080", "proxy.corp:3128"}
+	want.WatchedValues = []WatchedValue{
+		{Hive: "HKCU", KeyPath: `Software\Example`, ValueName: "Mode", Type: "dword"},
+	}
+
+	for name, contents := range EQUIVALENT_CONFIGS {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), name)
+			err := os.WriteFile(configPath, []byte(contents), 0600)
+			if err != nil {
+				t.Fatalf("Failed to write the config: %v", err)
+			}
+
+			got, problems, err := readConfigFile(configPath)
+			if err != nil {
+				t.Fatalf("readConfigFile failed: %v", err)
+			}
+
+			if len(problems) > 0 {
+				t.Errorf("readConfigFile found problems: %+v", problems)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("readConfigFile = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
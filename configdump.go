@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Printed instead of settings that shouldn't end up in a support ticket
+const REDACTED_VALUE = "<redacted>"
+
+// Prints the config in effect as indented JSON and returns the exit code.
+// The running monitor is asked for its live config first, since it may have
+// been started with options or reloaded since. Without one, the config is
+// worked out from the config file, the defaults and the environment, the
+// same way starting the monitor would, but the config file is never created
+func printEffectiveConfig(redact bool) int {
+	config, err := queryLiveConfig(redact)
+	if errors.Is(err, errConfigAccessDenied) {
+		printError("The monitor runs as a service and only shares its config with -redact")
+		return 1
+	}
+
+	if err != nil {
+		config, err = readEffectiveConfig()
+		if err != nil {
+			printError("Failed to load config file:", err)
+			return 1
+		}
+	}
+
+	if redact {
+		config = redactConfig(config)
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		printError("Failed to encode config:", err)
+		return 1
+	}
+
+	fmt.Println(string(data))
+	return 0
+}
+
+// Returned by queryLiveConfig when the main instance won't share its secrets
+var errConfigAccessDenied = errors.New("access denied")
+
+// Asks the main instance for the config it's running with, without the
+// secrets if redact is set
+func queryLiveConfig(redact bool) (Config, error) {
+	conn, err := dialControl(pipeName, PIPE_TIMEOUT)
+	if err != nil {
+		return Config{}, err
+	}
+
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(PIPE_TIMEOUT))
+	if err != nil {
+		return Config{}, err
+	}
+
+	response, err := exchangeCommand(conn, command{id: CMD_CONFIG, redact: redact})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if len(response) == 1 && response[0] == RESPONSE_ACCESS_DENIED {
+		return Config{}, errConfigAccessDenied
+	}
+
+	// Monitors from before this command answer with an error code
+	if len(response) <= 1 {
+		return Config{}, errors.New("monitor doesn't support the config command")
+	}
+
+	var config Config
+	err = json.Unmarshal(response, &config)
+	return config, err
+}
+
+// Works out the config from the config file, without writing anything. A
+// missing config file means every setting is at its default
+func readEffectiveConfig() (Config, error) {
+	config := defaultConfig()
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		return applyEnvOverrides(config), nil
+	}
+
+	loaded, problems, err := readConfigFile(configFilePath(dataDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return applyEnvOverrides(config), nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	printConfigProblems(problems)
+	return applyEnvOverrides(loaded), nil
+}
+
+// Hides the settings that can hold secrets, like tokens in the webhook URL
+func redactConfig(config Config) Config {
+	if config.WebhookURL != "" {
+		config.WebhookURL = REDACTED_VALUE
+	}
+
+	if config.HTTPToken != "" {
+		config.HTTPToken = REDACTED_VALUE
+	}
+
+	return config
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Extensions the config file can have, in the order they're looked for.
+// JSON comes first and is what's created when there's no config file yet
+var CONFIG_EXTENSIONS = []string{".json", ".toml", ".yaml", ".yml"}
+
+// Finds the config file in the data directory, whichever format it's in. If
+// there's none yet, the JSON path is returned, which is where the defaults
+// are written to
+func configFilePath(dataDir string) string {
+	base := strings.TrimSuffix(configFileName, filepath.Ext(configFileName))
+
+	for _, ext := range CONFIG_EXTENSIONS {
+		path := filepath.Join(dataDir, base+ext)
+
+		_, err := os.Stat(path)
+		if err == nil {
+			return path
+		}
+	}
+
+	return filepath.Join(dataDir, configFileName)
+}
+
+// Converts a TOML or YAML config file into JSON, so every format goes
+// through the same JSON keys and validation. JSON is passed through as is
+func configToJSON(configPath string, data []byte) ([]byte, error) {
+	var settings map[string]any
+	var err error
+
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	default:
+		return data, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty YAML file decodes to nothing, which means every setting is
+	// at its default
+	if settings == nil {
+		return []byte("{}"), nil
+	}
+
+	// YAML allows keys that aren't strings, which JSON doesn't
+	converted, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value in config file: %w", err)
+	}
+
+	return converted, nil
+}
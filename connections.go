@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Copy of the default connection's settings that Windows keeps around, not a
+// connection of its own
+const SAVED_LEGACY_SETTINGS_VALUE = "SavedLegacySettings"
+
+// Flag in the connection settings that's set when "Use a proxy server" is
+// turned on for the connection
+const CONNECTION_FLAG_PROXY uint32 = 0x02
+
+// Flag that's set when "Use automatic configuration script" is turned on
+const CONNECTION_FLAG_AUTO_CONFIG uint32 = 0x04
+
+// Proxy settings of a single connection, decoded from its binary value in
+// the Connections key
+type connectionSettings struct {
+	flags         uint32
+	proxyServer   string
+	autoConfigURL string
+}
+
+// Whether the connection uses a proxy server
+func (s connectionSettings) proxyEnabled() bool {
+	return s.flags&CONNECTION_FLAG_PROXY != 0
+}
+
+// Decodes a connection settings blob. After the version, change counter and
+// flags DWORDs come the proxy server, the bypass list and the auto-config
+// URL, each as a DWORD length followed by that many bytes of text. Whatever
+// follows isn't needed
+func parseConnectionSettings(data []byte) (connectionSettings, error) {
+	flags, err := parseConnectionFlags(data)
+	if err != nil {
+		return connectionSettings{}, err
+	}
+
+	settings := connectionSettings{flags: flags}
+	offset := 12
+
+	// A nested function that reads the next length-prefixed string
+	var readString = func() (string, error) {
+		if len(data) < offset+4 {
+			return "", errors.New("connection settings value is truncated")
+		}
+
+		length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+
+		if length < 0 || len(data)-offset < length {
+			return "", errors.New("connection settings value is truncated")
+		}
+
+		str := string(data[offset : offset+length])
+		offset += length
+		return strings.TrimRight(str, "\x00"), nil
+	}
+
+	settings.proxyServer, err = readString()
+	if err != nil {
+		return settings, err
+	}
+
+	// The bypass list, which isn't logged
+	_, err = readString()
+	if err != nil {
+		return settings, err
+	}
+
+	// Older versions of Windows leave the auto-config URL out entirely
+	if len(data) >= offset+4 {
+		settings.autoConfigURL, err = readString()
+		if err != nil {
+			return settings, err
+		}
+	}
+
+	return settings, nil
+}
+
+// Reads the settings of every dial-up and VPN connection in the hive, keyed
+// by the connection name. The default connection is left out, its proxy
+// settings are already watched through the Internet Settings key. A value
+// that can't be decoded is only reported once, until it can be again
+func readConnectionSettings(hive proxymon.Hive, failures *failureReporter) (map[string]connectionSettings, error) {
+	connections := map[string]connectionSettings{}
+
+	key, err := proxymon.OpenKey(hive, CONNECTIONS_KEY)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return connections, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+
+	names, err := key.ReadValueNames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if strings.EqualFold(name, DEFAULT_CONNECTION_SETTINGS_VALUE) || strings.EqualFold(name, SAVED_LEGACY_SETTINGS_VALUE) {
+			continue
+		}
+
+		data, _, err := key.GetBinaryValue(name)
+		if err != nil {
+			// Other kinds of values can end up in the key too, those aren't
+			// connections
+			continue
+		}
+
+		settings, err := parseConnectionSettings(data)
+		if err != nil {
+			if failures.shouldReport(name, err) {
+				printErrorf("Failed to decode the settings of connection %s: %v\n", name, err)
+			}
+			continue
+		}
+
+		failures.clear(name)
+		connections[name] = settings
+	}
+
+	return connections, nil
+}
+
+// Formats a connection's proxy settings for a log line
+func formatConnectionProxy(name string, settings connectionSettings) string {
+	message := "connection " + name + ": proxy off"
+	if settings.proxyEnabled() {
+		message = "connection " + name + ": proxy on, " + displayProxyServer(settings.proxyServer)
+	}
+
+	if settings.flags&CONNECTION_FLAG_AUTO_CONFIG != 0 && settings.autoConfigURL != "" {
+		message += ", auto-config " + settings.autoConfigURL
+	}
+
+	return message
+}
+
+// Checks the proxy settings of the hive's dial-up and VPN connections in a
+// loop, and logs which connection's proxy changed. The connections that
+// exist when the monitor starts are only the baseline, so they aren't logged
+func watchConnections(ctx context.Context, hive proxymon.Hive, prefix string, logFile *monitorLog) {
+	var last map[string]connectionSettings
+
+	// Keyed by the connection name, the key itself failing uses an empty one
+	var failures failureReporter
+
+	for {
+		config := currentConfig()
+
+		if controller.Enabled() {
+			connections, err := readConnectionSettings(hive, &failures)
+
+			if err != nil {
+				if failures.shouldReport("", err) {
+					printError(prefix+"Failed to read connection settings:", err)
+				}
+			} else {
+				failures.clear("")
+
+				if last != nil {
+					for _, message := range connectionChanges(last, connections) {
+						writeLogEntry(logFile, prefix+message)
+						writeSeverityEvent(config, SEVERITY_INFO, prefix+message)
+					}
+				}
+
+				last = connections
+			}
+		}
+
+		if !sleepContext(ctx, config.pollInterval()) {
+			return
+		}
+	}
+}
+
+// Lists the log lines for every connection whose proxy changed, was added
+// or was removed, sorted by the connection name
+func connectionChanges(previous map[string]connectionSettings, current map[string]connectionSettings) []string {
+	names := []string{}
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	messages := []string{}
+	for _, name := range names {
+		old, existed := previous[name]
+		settings, exists := current[name]
+
+		switch {
+		case !exists:
+			messages = append(messages, "connection "+name+" removed")
+		case !existed:
+			messages = append(messages, formatConnectionProxy(name, settings)+" (new connection)")
+		case formatConnectionProxy(name, old) != formatConnectionProxy(name, settings):
+			messages = append(messages, formatConnectionProxy(name, settings))
+		}
+	}
+
+	return messages
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// Reports whether stdout is a terminal, which are assumed to understand the
+// color escape codes
+func consoleSupportsColor() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reports whether stdout is a console that understands the color escape
+// codes. Windows 10 and later do, once virtual terminal processing has been
+// turned on for the console, which fails on older versions
+func consoleSupportsColor() bool {
+	handle := windows.Handle(os.Stdout.Fd())
+
+	var mode uint32
+	err := windows.GetConsoleMode(handle, &mode)
+	if err != nil {
+		// Not a console, like when the output is redirected to a file
+		return false
+	}
+
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+
+	err = windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	return err == nil
+}
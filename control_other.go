@@ -0,0 +1,64 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Name of the Unix domain socket used instead of the named pipe outside of
+// Windows, kept in the temp directory
+const SOCKET_FILE = "proxymonitor"
+
+// Returned by Accept once the socket listener has been closed
+var errListenerClosed = net.ErrClosed
+
+// Path of the socket used by the given monitor instance
+func pipeNameFor(instance string) string {
+	name := SOCKET_FILE
+	if instance != "" {
+		name += "-" + instance
+	}
+
+	return filepath.Join(os.TempDir(), name+".sock")
+}
+
+// Starts listening on the socket. Only the main instance, which holds the lock
+// file, listens, so a socket file that already exists was left behind by one
+// that crashed and can be removed. Unless something still accepts connections
+// on it, removing it would cut that monitor off from its clients
+func listenControl(name string) (net.Listener, error) {
+	conn, err := net.DialTimeout("unix", name, PIPE_TIMEOUT)
+	if err == nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: name, Net: "unix"}, Err: syscall.EADDRINUSE}
+	}
+
+	err = os.Remove(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return net.Listen("unix", name)
+}
+
+// Connects to the socket, failing after the timeout
+func dialControl(name string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", name, timeout)
+}
+
+// Reports whether dialing failed because nothing is listening on the socket
+func isNoListener(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Reports whether listening failed because another process is listening on
+// the socket
+func isPipeInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
@@ -0,0 +1,60 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	// Named pipes library
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+)
+
+// Name of the named pipe used to communicate between the main monitor
+// process and newer instances
+const PIPE_FILE = `\\.\pipe\proxymonitor`
+
+// Returned by Accept once the pipe listener has been closed
+var errListenerClosed = winio.ErrPipeListenerClosed
+
+// Name of the pipe used by the given monitor instance
+func pipeNameFor(instance string) string {
+	if instance == "" {
+		return PIPE_FILE
+	}
+
+	return PIPE_FILE + "-" + instance
+}
+
+// Starts listening on the named pipe
+func listenControl(name string) (net.Listener, error) {
+	// A service runs as a different user than the clients, so it has to
+	// explicitly allow them to connect
+	var pipeConfig *winio.PipeConfig
+	if runningAsService {
+		pipeConfig = &winio.PipeConfig{SecurityDescriptor: SERVICE_PIPE_SECURITY}
+	}
+
+	return winio.ListenPipe(name, pipeConfig)
+}
+
+// Connects to the named pipe, failing after the timeout if the main instance
+// doesn't accept the connection
+func dialControl(name string, timeout time.Duration) (net.Conn, error) {
+	return winio.DialPipe(name, &timeout)
+}
+
+// Reports whether dialing failed because nothing is listening on the pipe
+func isNoListener(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// Reports whether listening failed because another process already created
+// the pipe. The listener asks for the first instance of the pipe, so that
+// fails with a name collision rather than quietly sharing it
+func isPipeInUse(err error) bool {
+	return errors.Is(err, windows.ERROR_ALREADY_EXISTS) || errors.Is(err, windows.ERROR_PIPE_BUSY)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Owns the monitoring state. The pipe, the tray and the HTTP server all change
+// it from their own goroutines, so every method is synchronized and commands
+// are carried out one at a time
+type Controller struct {
+	mutex sync.Mutex
+
+	// Whether the watchers are currently checking for changes
+	enabled bool
+
+	// Whether monitoring is held off because the session is locked. Kept
+	// apart from enabled, so stopping or starting while locked still counts
+	// once the session is unlocked
+	sessionLocked bool
+
+	// Timer that resumes monitoring after a pause, nil when not paused
+	pauseTimer *time.Timer
+
+	// Called with the new state whenever monitoring is turned on or off
+	subscribers []func(bool)
+
+	// When the monitor was started, for the uptime
+	startedAt time.Time
+
+	// Most recent error a watcher ran into, which watcher it was and when.
+	// Cleared once that watcher reads the registry successfully again
+	lastError       string
+	lastErrorSource string
+	lastErrorAt     time.Time
+
+	// Shuts the monitor down when quitting. The tests replace it, so
+	// quitting doesn't exit them
+	shutdown func()
+}
+
+// The controller shared by every front-end of the monitor
+var controller = newController()
+
+// Creates a controller that starts out monitoring
+func newController() *Controller {
+	return &Controller{enabled: true, startedAt: time.Now(), shutdown: shutdown}
+}
+
+// Registers a function that's called whenever monitoring is turned on or off.
+// Subscribers are called with the controller's mutex held, so they must not
+// call back into the controller
+func (c *Controller) Subscribe(subscriber func(bool)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.subscribers = append(c.subscribers, subscriber)
+}
+
+// Reports whether monitoring is currently turned on and not held off by a
+// locked session
+func (c *Controller) Enabled() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.active()
+}
+
+// Turns monitoring on, cancelling a pause if there is one
+func (c *Controller) Start() byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Monitoring can't be started if reading the registry is broken
+	if anyWatcherFailed() {
+		return RESPONSE_REGISTRY_ERROR
+	}
+
+	// Starting manually while paused means there's no need to resume
+	// automatically anymore
+	c.cancelPause()
+
+	if c.enabled {
+		return RESPONSE_ALREADY_IN_STATE
+	}
+
+	c.setEnabled(true)
+	return RESPONSE_OK
+}
+
+// Turns monitoring off. Stopping while paused turns the pause into a regular
+// stop
+func (c *Controller) Stop() byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cancelPause() {
+		return RESPONSE_OK
+	}
+
+	if !c.enabled {
+		return RESPONSE_ALREADY_IN_STATE
+	}
+
+	c.setEnabled(false)
+	return RESPONSE_OK
+}
+
+// Stops monitoring and schedules it to resume after the given duration.
+// Pausing again while already paused restarts the countdown. Returns
+// RESPONSE_ALREADY_IN_STATE if monitoring was stopped without a pause
+func (c *Controller) Pause(duration time.Duration) byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.enabled && c.pauseTimer == nil {
+		return RESPONSE_ALREADY_IN_STATE
+	}
+
+	c.cancelPause()
+
+	if c.enabled {
+		c.setEnabled(false)
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		// The pause was cancelled or replaced by a newer one
+		if c.pauseTimer != timer {
+			return
+		}
+
+		c.pauseTimer = nil
+		c.setEnabled(true)
+		writeActiveLogEntry("resumed after pause")
+		printInfo("Auto-resumed after pause")
+	})
+
+	c.pauseTimer = timer
+	writeActiveLogEntry("paused for " + duration.String())
+	printInfo("Paused for", duration)
+	return RESPONSE_OK
+}
+
+// Holds off monitoring while the session is locked, if pause_when_locked is
+// on. Monitoring that was already stopped stays stopped
+func (c *Controller) SessionLocked() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.sessionLocked || !currentConfig().PauseWhenLocked {
+		return
+	}
+
+	c.sessionLocked = true
+	if !c.enabled {
+		return
+	}
+
+	c.notifySubscribers()
+	writeActiveLogEntry("paused while session is locked")
+	printInfo("Paused while the session is locked")
+}
+
+// Picks monitoring back up after the session was unlocked, unless it was
+// stopped in the meantime
+func (c *Controller) SessionUnlocked() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.sessionLocked {
+		return
+	}
+
+	c.sessionLocked = false
+	if !c.enabled {
+		return
+	}
+
+	c.notifySubscribers()
+	writeActiveLogEntry("resumed after session unlock")
+	printInfo("Resumed after session unlock")
+}
+
+// Forgets the last known proxy settings, so the current ones are logged again
+func (c *Controller) Restart() byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if anyWatcherFailed() {
+		return RESPONSE_REGISTRY_ERROR
+	}
+
+	resetWatchStates()
+	printInfo("Reset the monitor's baseline state")
+	return RESPONSE_OK
+}
+
+// Re-reads the config file, keeping the old config if the new one can't be
+// applied
+func (c *Controller) Reload() byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	err := reloadConfig()
+	if err != nil {
+		printError("Failed to reload config:", err)
+		return RESPONSE_CONFIG_ERROR
+	}
+
+	return RESPONSE_OK
+}
+
+// Clears the log file, leaving a "log cleared" line as the new baseline
+func (c *Controller) ClearLog() byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	logMutex.Lock()
+	logFile := activeLog
+	logMutex.Unlock()
+
+	if logFile == nil {
+		printError("Failed to clear log file: the log hasn't been opened yet")
+		return RESPONSE_INTERNAL_ERROR
+	}
+
+	err := logFile.clear()
+	if err != nil {
+		printError("Failed to clear log file:", err)
+		return RESPONSE_INTERNAL_ERROR
+	}
+
+	printInfo("Cleared the log file")
+	return RESPONSE_OK
+}
+
+// Exits the program. Takes the mutex so a command that's still running
+// finishes first, but lets go of it before shutting down, since the watchers
+// need it to get to the point where they notice the shutdown
+func (c *Controller) Quit() {
+	c.mutex.Lock()
+	printInfo("Exiting...")
+	c.mutex.Unlock()
+
+	c.shutdown()
+}
+
+// Remembers an error a watcher ran into, so it shows up in the status even
+// when nobody is looking at the console
+func (c *Controller) ReportError(source string, message string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastError = message
+	c.lastErrorSource = source
+	c.lastErrorAt = time.Now()
+}
+
+// Forgets the last error, if it came from the given watcher
+func (c *Controller) ClearError(source string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.lastErrorSource == source {
+		c.lastError = ""
+		c.lastErrorSource = ""
+		c.lastErrorAt = time.Time{}
+	}
+}
+
+// Collects the monitoring state and the last known settings of every watcher
+func (c *Controller) Status() monitorStatus {
+	c.mutex.Lock()
+	status := monitorStatus{
+		Monitoring:    c.active(),
+		SessionLocked: c.enabled && c.sessionLocked,
+		Hives:         []hiveStatus{},
+		UptimeSeconds: int64(time.Since(c.startedAt).Seconds()),
+		LastError:     c.lastError,
+	}
+
+	if c.lastError != "" {
+		lastErrorAt := c.lastErrorAt
+		status.LastErrorAt = &lastErrorAt
+	}
+	c.mutex.Unlock()
+
+	watchStatesMutex.Lock()
+	defer watchStatesMutex.Unlock()
+
+	for _, state := range watchStates {
+		state.mutex.Lock()
+		status.Hives = append(status.Hives, hiveStatus{
+			Hive:         state.hive,
+			ProxyEnabled: state.proxyEnable != 0 && state.proxyEnable != UNKNOWN_PROXY_ENABLE,
+			ProxyServer:  state.proxyServer,
+		})
+		status.Stats.add(state.stats)
+		state.mutex.Unlock()
+	}
+
+	return status
+}
+
+// Changes the monitoring state and lets the subscribers know, must be called
+// with the mutex held
+func (c *Controller) setEnabled(enabled bool) {
+	c.enabled = enabled
+	c.notifySubscribers()
+
+	switch {
+	case enabled && c.sessionLocked:
+		printInfo("Will listen to proxy changes once the session is unlocked")
+	case enabled:
+		printInfo("Now listening to proxy changes")
+	default:
+		printInfo("No longer listening to proxy changes")
+	}
+}
+
+// Whether the watchers should be checking for changes, must be called with
+// the mutex held
+func (c *Controller) active() bool {
+	return c.enabled && !c.sessionLocked
+}
+
+// Lets the subscribers know whether monitoring is on, must be called with the
+// mutex held
+func (c *Controller) notifySubscribers() {
+	active := c.active()
+
+	for _, subscriber := range c.subscribers {
+		subscriber(active)
+	}
+}
+
+// Cancels a pending automatic resume, must be called with the mutex held.
+// Returns true if monitoring was paused
+func (c *Controller) cancelPause() bool {
+	if c.pauseTimer == nil {
+		return false
+	}
+
+	c.pauseTimer.Stop()
+	c.pauseTimer = nil
+	return true
+}
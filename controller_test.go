@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPauseLogsAutoResume(t *testing.T) {
+	config := testWatcherConfig(t)
+
+	logMutex.Lock()
+	previousLog := activeLog
+	logMutex.Unlock()
+
+	t.Cleanup(func() {
+		logMutex.Lock()
+		activeLog = previousLog
+		logMutex.Unlock()
+	})
+
+	logFile, err := openMonitorLog(config)
+	if err != nil {
+		t.Fatalf("Failed to open the log: %v", err)
+	}
+
+	testController := newController()
+
+	response := testController.Pause(20 * time.Millisecond)
+	if response != RESPONSE_OK {
+		t.Fatalf("Pause = %d, want %d", response, RESPONSE_OK)
+	}
+
+	if testController.Enabled() {
+		t.Error("monitoring still enabled after pausing")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !testController.Enabled() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if !testController.Enabled() {
+		t.Fatal("monitoring never resumed after the pause")
+	}
+
+	logFile.close()
+
+	got := readLogMessages(t, config.LogPath)
+	want := []string{"paused for 20ms", "resumed after pause"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+}
+
+func TestStatusLastErrorAt(t *testing.T) {
+	testController := newController()
+
+	encodeStatus := func() string {
+		data, err := json.Marshal(testController.Status())
+		if err != nil {
+			t.Fatalf("Failed to encode the status: %v", err)
+		}
+
+		return string(data)
+	}
+
+	if status := encodeStatus(); strings.Contains(status, "last_error") {
+		t.Errorf("status without an error = %s, want no last_error fields", status)
+	}
+
+	testController.ReportError("HKCU", "failed to read ProxyEnable: access denied")
+	if status := encodeStatus(); !strings.Contains(status, `"last_error_at":"`) {
+		t.Errorf("status with an error = %s, want a last_error_at", status)
+	}
+
+	testController.ClearError("HKCU")
+	if status := encodeStatus(); strings.Contains(status, "last_error") {
+		t.Errorf("status after the error cleared = %s, want no last_error fields", status)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Result of one of the -doctor checks
+type doctorCheck struct {
+	name string
+
+	// What was found, shown next to the name when the check passed
+	detail string
+
+	// Why the check failed and what to do about it, nil when it passed
+	err  error
+	hint string
+}
+
+// Checks that the monitor has everything it needs to run, going through the
+// same paths starting it would, and prints a checklist. Nothing is changed,
+// apart from the log file being created if it doesn't exist yet, the same as
+// the monitor would. Returns the exit code, 0 if every check passed
+func runDoctor() int {
+	checks := []doctorCheck{}
+
+	dataDir, err := getDataDir()
+	if err != nil {
+		checks = append(checks, doctorCheck{
+			name: "Data directory",
+			err:  err,
+			hint: "set APPDATA, or set " + LOG_DIR_ENV + " so there's somewhere to log to",
+		})
+	} else {
+		checks = append(checks, doctorCheck{name: "Data directory", detail: dataDir})
+	}
+
+	config, check := checkConfigFile(dataDir)
+	checks = append(checks, check)
+
+	// The config has already been validated, so this can't fail
+	hives, _ := parseRegistryHives(config.RegistryHive)
+	for _, hive := range hives {
+		checks = append(checks, checkRegistryKey(hive))
+	}
+
+	checks = append(checks, checkLogFile(config))
+	checks = append(checks, checkControlPipe())
+
+	failed := 0
+	for _, check := range checks {
+		if check.err == nil {
+			printColored(ANSI_GREEN, "[ OK ] "+check.name+": "+check.detail)
+			continue
+		}
+
+		failed++
+		printColored(ANSI_RED, "[FAIL] "+check.name+": "+check.err.Error())
+		if check.hint != "" {
+			fmt.Println("       Hint:", check.hint)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+
+	fmt.Println("Everything looks good")
+	return 0
+}
+
+// Reads the config file, if there is one. Returns the config the other checks
+// should use, falling back to the defaults when it can't be read
+func checkConfigFile(dataDir string) (Config, doctorCheck) {
+	defaults := applyEnvOverrides(defaultConfig())
+	if dataDir == "" {
+		return defaults, doctorCheck{name: "Config file", detail: "no data directory, using defaults"}
+	}
+
+	path := configFilePath(dataDir)
+	loaded, problems, err := readConfigFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return defaults, doctorCheck{name: "Config file", detail: path + " doesn't exist yet, using defaults"}
+	}
+	if err != nil {
+		return defaults, doctorCheck{
+			name: "Config file",
+			err:  err,
+			hint: "fix " + path + ", or delete it to have it recreated with the defaults",
+		}
+	}
+
+	// The monitor still starts with invalid settings, but they've been
+	// reset to their defaults, which is probably not what was meant
+	err = configProblemsError(problems)
+	if err != nil {
+		return applyEnvOverrides(loaded), doctorCheck{
+			name: "Config file",
+			err:  err,
+			hint: "fix the settings in " + path + ", until then they use their defaults",
+		}
+	}
+
+	return applyEnvOverrides(loaded), doctorCheck{name: "Config file", detail: path}
+}
+
+// Opens the hive's Internet Settings key and reads ProxyEnable, the same way
+// the watchers do
+func checkRegistryKey(hive proxymon.Hive) doctorCheck {
+	name := string(hive) + " Internet Settings"
+	hint := "check that this user is allowed to read the key"
+	if hive == proxymon.HIVE_HKLM {
+		hint = "run as an administrator, or set registry_hive to HKCU"
+	}
+
+	key, err := proxymon.OpenKey(hive, proxymon.INTERNET_SETTINGS_KEY)
+
+	// Normal for profiles that never set up a proxy, they're just skipped
+	_, isUser := hive.UserSID()
+	if isUser && errors.Is(err, proxymon.ErrNotExist) {
+		return doctorCheck{name: name, detail: "no proxy settings, not monitored"}
+	}
+
+	if err != nil {
+		return doctorCheck{name: name, err: err, hint: hint}
+	}
+	defer key.Close()
+
+	_, _, err = key.GetIntegerValue("ProxyEnable")
+	if err != nil && !errors.Is(err, proxymon.ErrNotExist) {
+		return doctorCheck{name: name, err: err, hint: hint}
+	}
+
+	return doctorCheck{name: name, detail: "readable"}
+}
+
+// Makes sure the log file can be written to
+func checkLogFile(config Config) doctorCheck {
+	path := config.logFilePath(time.Now())
+
+	err := validateLogPath(path)
+	if err != nil {
+		return doctorCheck{
+			name: "Log file",
+			err:  err,
+			hint: "set " + LOG_DIR_ENV + " or log_path in the config to a writable location",
+		}
+	}
+
+	return doctorCheck{name: "Log file", detail: path + " is writable"}
+}
+
+// Checks that the control pipe can be listened on. If a monitor is running
+// and answers on it, that's as good as being able to listen
+func checkControlPipe() doctorCheck {
+	if pingPipe() == nil {
+		return doctorCheck{name: "Control pipe", detail: "in use by the running monitor"}
+	}
+
+	listener, err := listenControl(pipeName)
+	if err != nil {
+		return doctorCheck{
+			name: "Control pipe",
+			err:  err,
+			hint: "another program may be holding " + pipeName + ", close any stuck proxy-monitor processes",
+		}
+	}
+
+	listener.Close()
+	return doctorCheck{name: "Control pipe", detail: pipeName + " is free"}
+}
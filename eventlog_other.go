@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// The Event Log only exists on Windows, so turning it on always fails with a
+// warning and the monitor keeps using the file log
+func openEventLog() error {
+	return errors.New("the event log is only available on Windows")
+}
+
+func writeEvent(message string) {}
+
+func writeWarningEvent(message string) {}
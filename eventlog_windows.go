@@ -0,0 +1,70 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Name of the event source proxy changes are written to the Event Log as
+const EVENT_SOURCE = "ProxyMonitor"
+
+// Event ID used for every proxy change event
+const EVENT_ID_PROXY_CHANGE = 1
+
+// Registry key event sources are registered under
+const EVENT_SOURCE_KEY = `SYSTEM\CurrentControlSet\Services\EventLog\Application\` + EVENT_SOURCE
+
+// Handle to the Windows Event Log, nil when event logging is turned off or
+// the event source couldn't be registered
+var eventLog *eventlog.Log
+
+// Registers the event source if it doesn't exist yet and opens the Event Log.
+// Registering the source requires admin rights, but only has to be done once
+func openEventLog() error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, EVENT_SOURCE_KEY, registry.QUERY_VALUE)
+	if err == nil {
+		key.Close()
+	} else {
+		err = eventlog.InstallAsEventCreate(EVENT_SOURCE, eventlog.Info|eventlog.Warning|eventlog.Error)
+		if err != nil {
+			return fmt.Errorf("failed to register event source: %w", err)
+		}
+	}
+
+	eventLog, err = eventlog.Open(EVENT_SOURCE)
+	if err != nil {
+		return err
+	}
+
+	onShutdown(func() { eventLog.Close() })
+	return nil
+}
+
+// Writes an information event to the Event Log, if it's open
+func writeEvent(message string) {
+	if eventLog == nil {
+		return
+	}
+
+	err := eventLog.Info(EVENT_ID_PROXY_CHANGE, message)
+	if err != nil {
+		printError("Failed to write to the event log:", err)
+	}
+}
+
+// Writes a warning event to the Event Log, if it's open, for changes that
+// need a closer look
+func writeWarningEvent(message string) {
+	if eventLog == nil {
+		return
+	}
+
+	err := eventLog.Warning(EVENT_ID_PROXY_CHANGE, message)
+	if err != nil {
+		printError("Failed to write to the event log:", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of proxy changes that can be picked in log_events
+const EVENT_ENABLE = "enable"
+const EVENT_DISABLE = "disable"
+const EVENT_SERVER_CHANGE = "server_change"
+
+// Kinds of changes to the settings around the proxy, logged by their own
+// watchers. A PAC change covers the PAC file and auto-detect (WPAD)
+const EVENT_OVERRIDE_CHANGE = "override_change"
+const EVENT_PAC_CHANGE = "pac_change"
+
+// Every kind of change, logged when log_events isn't set
+var ALL_LOG_EVENTS = []string{EVENT_ENABLE, EVENT_DISABLE, EVENT_SERVER_CHANGE, EVENT_OVERRIDE_CHANGE, EVENT_PAC_CHANGE}
+
+// How much a change matters. Each sink only gets the changes at or above its
+// threshold, so toasts can be kept to the proxy being turned on or off while
+// the webhook still gets everything
+const SEVERITY_INFO = "info"
+const SEVERITY_NOTABLE = "notable"
+
+// Every severity, from the least to the most severe
+var SEVERITY_LEVELS = []string{SEVERITY_INFO, SEVERITY_NOTABLE}
+
+// Works out what kind of change going from the previous settings to the new
+// ones is. A reset baseline counts as the proxy being turned on or off
+func changeEventKind(previousEnable uint64, proxyEnable uint64) string {
+	if proxyEnable == 0 {
+		return EVENT_DISABLE
+	}
+
+	wasOn := previousEnable != 0 && previousEnable != UNKNOWN_PROXY_ENABLE
+	if wasOn {
+		return EVENT_SERVER_CHANGE
+	}
+
+	return EVENT_ENABLE
+}
+
+// Turning the proxy on or off is notable, swapping the server is only info
+func eventSeverity(kind string) string {
+	switch kind {
+	case EVENT_ENABLE, EVENT_DISABLE:
+		return SEVERITY_NOTABLE
+	default:
+		return SEVERITY_INFO
+	}
+}
+
+// Where the severity is in SEVERITY_LEVELS, -1 if it isn't one
+func severityRank(severity string) int {
+	for i, level := range SEVERITY_LEVELS {
+		if severity == level {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Returns an error if the name isn't a known severity
+func validateSeverity(severity string) error {
+	if severityRank(severity) < 0 {
+		return fmt.Errorf("unknown severity %q, expected one of %s", severity, strings.Join(SEVERITY_LEVELS, ", "))
+	}
+
+	return nil
+}
+
+// Reports whether a change of the given severity is sent to a sink with the
+// given threshold
+func meetsThreshold(severity string, threshold string) bool {
+	return severityRank(severity) >= severityRank(threshold)
+}
+
+// Writes to the Event Log, if the change is severe enough for it. Changes
+// other than the proxy's own, like auto-detect or a PAC file, count as info
+func writeSeverityEvent(config Config, severity string, message string) {
+	if meetsThreshold(severity, config.EventLogSeverity) {
+		writeEvent(message)
+	}
+}
+
+// Returns an error if any of the names isn't a known kind of change
+func validateLogEvents(events []string) error {
+	for _, event := range events {
+		known := false
+		for _, kind := range ALL_LOG_EVENTS {
+			if event == kind {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("unknown event %q, expected one of %s", event, strings.Join(ALL_LOG_EVENTS, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Reports whether changes of the given kind should be written to the log
+func (c Config) logsEvent(kind string) bool {
+	for _, event := range c.LogEvents {
+		if event == kind {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Cleans up the expected_proxies entries the same way logged servers are, so
+// "Proxy.Corp:8080/" matches "proxy.corp:8080". Empty entries are dropped
+func normalizeExpectedProxies(expected []string) []string {
+	normalized := []string{}
+
+	for _, endpoint := range expected {
+		endpoint = normalizeEndpoint(endpoint)
+		if endpoint == "" {
+			printWarning("Empty entry in expected_proxies in config, skipping")
+			continue
+		}
+
+		normalized = append(normalized, endpoint)
+	}
+
+	return normalized
+}
+
+// Returns the endpoints of a ProxyServer value that aren't in the expected
+// list, sorted. With per-protocol proxies, every protocol's endpoint has to be
+// expected. An empty list expects every server
+func unexpectedProxies(raw string, expected []string) []string {
+	if len(expected) == 0 {
+		return nil
+	}
+
+	unexpected := []string{}
+	seen := map[string]bool{}
+
+	// Every entry counts, even one that a later entry for the same protocol
+	// would override
+	entries, _ := splitProxyServer(raw)
+	for _, entry := range entries {
+		endpoint := entry.endpoint
+		if seen[endpoint] || isExpectedEndpoint(endpoint, expected) {
+			continue
+		}
+
+		seen[endpoint] = true
+		unexpected = append(unexpected, endpoint)
+	}
+
+	sort.Strings(unexpected)
+	return unexpected
+}
+
+// Reports whether the endpoint is in the list. A scheme on only one side
+// doesn't matter, so "proxy.corp:8080" also expects "http://proxy.corp:8080"
+func isExpectedEndpoint(endpoint string, expected []string) bool {
+	address, hasScheme := endpointAddress(endpoint)
+
+	for _, known := range expected {
+		knownAddress, knownHasScheme := endpointAddress(known)
+
+		if endpoint == known || (address == knownAddress && (!hasScheme || !knownHasScheme)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Cuts the scheme off a normalized endpoint, leaving the host:port. Returns
+// false if there was no scheme
+func endpointAddress(endpoint string) (string, bool) {
+	_, address, found := strings.Cut(endpoint, "://")
+	if !found {
+		return endpoint, false
+	}
+
+	return address, true
+}
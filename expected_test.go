@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnexpectedProxies(t *testing.T) {
+	expected := []string{"10.0.0.1:80", "proxy.corp:443"}
+
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{name: "expected", raw: "10.0.0.1:80", want: []string{}},
+		{name: "expected per protocol", raw: "http=10.0.0.1:80;https=https://proxy.corp:443", want: []string{}},
+		{name: "unexpected", raw: "10.0.0.9:80", want: []string{"10.0.0.9:80"}},
+		{name: "one protocol unexpected", raw: "http=10.0.0.1:80;https=10.0.0.9:443", want: []string{"10.0.0.9:443"}},
+		{name: "first of two bare hosts", raw: "10.0.0.9:80 10.0.0.1:80", want: []string{"10.0.0.9:80"}},
+		{name: "protocol given twice", raw: "http=10.0.0.9:80;http=10.0.0.1:80", want: []string{"10.0.0.9:80"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := unexpectedProxies(test.raw, expected)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("unexpectedProxies(%q) = %q, want %q", test.raw, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns of the CSV file written by -export
+var EXPORT_COLUMNS = []string{"timestamp", "event_type", "proxy_enabled", "proxy_server"}
+
+// Number at the start of a log line's message, with log_sequence
+var logLineSequence = regexp.MustCompile(`^#\d+ `)
+
+// Writes the recent proxy changes to a CSV file and returns the exit code.
+// The running monitor's history is used when there is one, otherwise the
+// changes are read back from the log file
+func exportHistory(path string) int {
+	entries, err := queryHistory()
+	if err == nil {
+		for i := range entries {
+			entries[i].Server = exportedProxyServer(entries[i])
+		}
+	} else {
+		config, err := readEffectiveConfig()
+		if err != nil {
+			printWarning("Failed to load config file, using defaults:", err)
+			config = applyEnvOverrides(defaultConfig())
+		}
+
+		entries, err = readLogHistory(config)
+		if err != nil {
+			printError("Failed to read proxy changes from the log file:", err)
+			return 1
+		}
+
+		printInfo("Monitor is not running, exporting the changes from the log file")
+	}
+
+	err = writeHistoryCSV(path, entries)
+	if err != nil {
+		printError("Failed to write", path+":", err)
+		return 1
+	}
+
+	printInfof("Exported %d proxy changes to %s\n", len(entries), path)
+	return 0
+}
+
+// Asks the main instance for its in-memory history
+func queryHistory() ([]historyEntry, error) {
+	conn, err := dialControl(pipeName, PIPE_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(PIPE_TIMEOUT))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := exchangeCommand(conn, command{id: CMD_HISTORY})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) <= 1 {
+		return nil, errors.New("monitor failed to send its history")
+	}
+
+	var entries []historyEntry
+	err = json.Unmarshal(response, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	fillHistoryEvents(entries)
+	return entries, nil
+}
+
+// Works out the kind of change for entries sent by monitors from before the
+// event was recorded, from the previous entry of the same hive
+func fillHistoryEvents(entries []historyEntry) {
+	previous := map[string]bool{}
+
+	for i := range entries {
+		entry := &entries[i]
+
+		if entry.Event == "" {
+			wasOn, seen := previous[entry.Hive]
+			switch {
+			case !entry.Enabled:
+				entry.Event = EVENT_DISABLE
+			case seen && wasOn:
+				entry.Event = EVENT_SERVER_CHANGE
+			default:
+				entry.Event = EVENT_ENABLE
+			}
+		}
+
+		previous[entry.Hive] = entry.Enabled
+	}
+}
+
+// Puts the server of an entry from the monitor's history in the form the log
+// file has it in, so an export reads the same whether the monitor was
+// running or not. The log has no server when the proxy is off
+func exportedProxyServer(entry historyEntry) string {
+	if !entry.Enabled {
+		return ""
+	}
+
+	server := displayProxyServer(entry.Server)
+	if server == NO_PROXY_SERVER {
+		return ""
+	}
+
+	return server
+}
+
+// Keeps a spreadsheet from running a cell as a formula. Anything could have
+// been written to ProxyServer, so a value starting with =, +, - or @ gets a
+// ' in front, which Excel hides
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// Writes the entries as CSV. Servers with commas in them, like per-protocol
+// lists, are quoted by the CSV writer
+func writeHistoryCSV(path string, entries []historyEntry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(file)
+	w.Write(EXPORT_COLUMNS)
+
+	for _, entry := range entries {
+		w.Write([]string{
+			entry.Time.Format(time.RFC3339),
+			csvCell(entry.Event),
+			strconv.FormatBool(entry.Enabled),
+			csvCell(entry.Server),
+		})
+	}
+
+	w.Flush()
+	err = w.Error()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Reads the proxy changes back from the log file. With daily rotation every
+// daily file is read, oldest first. Lines that aren't proxy changes, or that
+// can't be parsed, like ones written with another timestamp format, are
+// skipped
+func readLogHistory(config Config) ([]historyEntry, error) {
+	paths := []string{config.LogPath}
+
+	if config.Rotation == ROTATION_DAILY {
+		ext := filepath.Ext(config.LogPath)
+		pattern := strings.TrimSuffix(config.LogPath, ext) + "-*" + ext
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		// Left behind by compress_rotated
+		compressed, err := filepath.Glob(pattern + COMPRESSED_LOG_EXT)
+		if err != nil {
+			return nil, err
+		}
+
+		// The date format sorts the same as the dates
+		matches = append(matches, compressed...)
+		sort.Strings(matches)
+		paths = matches
+	}
+
+	location := time.Local
+	if config.TimestampUTC {
+		location = time.UTC
+	}
+
+	entries := []historyEntry{}
+	for _, path := range paths {
+		fileEntries, err := readLogFileHistory(path, config.timestampLayout(), location)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, fileEntries...)
+	}
+
+	return entries, nil
+}
+
+// Reads the proxy changes back from a single log file, which can be gzipped
+func readLogFileHistory(path string, layout string, location *time.Location) ([]historyEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, COMPRESSED_LOG_EXT) {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+
+		r = gz
+	}
+
+	entries := []historyEntry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		entry, ok := parseLogLine(scanner.Text(), layout, location)
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// Parses a proxy change line of the log file, like
+// "Mon Jan  2 15:04:05 2006\t[HKCU] proxy on, 10.0.0.1:8080 (connection: Wi-Fi)".
+// Returns false for every other kind of line
+func parseLogLine(line string, layout string, location *time.Location) (historyEntry, bool) {
+	timestamp, message, found := strings.Cut(line, "\t")
+	if !found {
+		return historyEntry{}, false
+	}
+
+	t, err := time.ParseInLocation(layout, timestamp, location)
+	if err != nil {
+		return historyEntry{}, false
+	}
+
+	entry := historyEntry{Time: t}
+
+	// Only there with log_sequence, the number doesn't matter here
+	message = logLineSequence.ReplaceAllString(message, "")
+
+	// Only there when several hives are watched
+	if strings.HasPrefix(message, "[") {
+		hive, rest, found := strings.Cut(message[1:], "] ")
+		if found {
+			entry.Hive = hive
+			message = rest
+		}
+	}
+
+	// Policy-enforced changes read the same once the note is gone, apart
+	// from "proxy on: " in place of "proxy on, "
+	message = strings.Replace(message, " "+POLICY_ENFORCED_NOTE, "", 1)
+
+	switch {
+	case message == "proxy off" || strings.HasPrefix(message, "proxy off "):
+		entry.Event = EVENT_DISABLE
+
+	case strings.HasPrefix(message, "proxy on, "), strings.HasPrefix(message, "proxy on: "):
+		entry.Event = EVENT_ENABLE
+		entry.Enabled = true
+		entry.Server = logLineServer(message[len("proxy on, "):])
+
+	case strings.HasPrefix(message, "proxy server changed: "):
+		_, servers, _ := strings.Cut(message, " -> ")
+		entry.Event = EVENT_SERVER_CHANGE
+		entry.Enabled = true
+		entry.Server = logLineServer(servers)
+
+	case strings.HasPrefix(message, "proxy changed while monitor was offline: "):
+		before, after, _ := strings.Cut(strings.TrimPrefix(message, "proxy changed while monitor was offline: "), " -> ")
+
+		entry.Enabled = strings.HasPrefix(after, "on, ")
+		if entry.Enabled {
+			entry.Server = logLineServer(strings.TrimPrefix(after, "on, "))
+		}
+
+		wasOn := strings.HasPrefix(before, "on, ")
+		switch {
+		case !entry.Enabled:
+			entry.Event = EVENT_DISABLE
+		case wasOn:
+			entry.Event = EVENT_SERVER_CHANGE
+		default:
+			entry.Event = EVENT_ENABLE
+		}
+
+	default:
+		return historyEntry{}, false
+	}
+
+	return entry, true
+}
+
+// Cuts the notes the watcher adds after the server, like the connection, the
+// origin and the context values, off the end of a log line
+func logLineServer(rest string) string {
+	end := len(rest)
+
+	for _, marker := range []string{" (", " ["} {
+		index := strings.Index(rest, marker)
+		if index >= 0 && index < end {
+			end = index
+		}
+	}
+
+	server := rest[:end]
+	if server == NO_PROXY_SERVER {
+		return ""
+	}
+
+	return server
+}
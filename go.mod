@@ -5,8 +5,11 @@ go 1.21.1
 require github.com/allan-simon/go-singleinstance v0.0.0-20210120080615-d0997106ab37
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/Microsoft/go-winio v0.6.2
+	github.com/getlantern/systray v1.2.2
 	golang.org/x/sys v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -16,7 +19,6 @@ require (
 	github.com/getlantern/hex v0.0.0-20190417191902-c6586a6fe0b7 // indirect
 	github.com/getlantern/hidden v0.0.0-20190325191715-f02dbb02be55 // indirect
 	github.com/getlantern/ops v0.0.0-20190325191751-d70cb0d6f85f // indirect
-	github.com/getlantern/systray v1.2.2 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
 )
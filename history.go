@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// How many proxy changes are kept in memory for the -history command
+const HISTORY_SIZE = 50
+
+// A single recorded proxy change
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Hive    string    `json:"hive"`
+	Enabled bool      `json:"enabled"`
+	Server  string    `json:"server"`
+
+	// Kind of change, one of the log_events names
+	Event string `json:"event,omitempty"`
+}
+
+// Ring buffer of the most recent proxy changes
+type historyBuffer struct {
+	mutex   sync.Mutex
+	entries []historyEntry
+	next    int
+	full    bool
+}
+
+// Proxy changes seen by every watcher since the monitor started
+var proxyHistory = newHistoryBuffer(HISTORY_SIZE)
+
+func newHistoryBuffer(size int) *historyBuffer {
+	return &historyBuffer{entries: make([]historyEntry, size)}
+}
+
+// Records a change, overwriting the oldest one if the buffer is full
+func (h *historyBuffer) add(entry historyEntry) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % len(h.entries)
+
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Returns a copy of the recorded changes, oldest first
+func (h *historyBuffer) list() []historyEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.full {
+		return append([]historyEntry{}, h.entries[:h.next]...)
+	}
+
+	result := make([]historyEntry, 0, len(h.entries))
+	result = append(result, h.entries[h.next:]...)
+	result = append(result, h.entries[:h.next]...)
+	return result
+}
+
+// Serializes the history for sending it over the pipe
+func encodeHistory() ([]byte, error) {
+	return json.Marshal(proxyHistory.list())
+}
+
+// Prints history received from the main program instance as a table
+func printHistory(payload []byte) error {
+	var entries []historyEntry
+	err := json.Unmarshal(payload, &entries)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No proxy changes recorded yet.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tHIVE\tPROXY\tSERVER")
+
+	for _, entry := range entries {
+		state := "off"
+		if entry.Enabled {
+			state = "on"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Time.Format(time.ANSIC), entry.Hive, state, entry.Server)
+	}
+
+	return w.Flush()
+}
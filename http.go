@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Address the HTTP server listens on by default. Only bound on loopback, so
+// the monitor can't be controlled from other machines unless http_addr says so
+const DEFAULT_HTTP_ADDR = "127.0.0.1:38080"
+
+// Header every control request has to carry. A web page can't add custom
+// headers to a cross-origin request without a CORS preflight, which is never
+// answered, so a page the user visits can't stop or quit the monitor
+const HTTP_TOKEN_HEADER = "X-Proxy-Monitor-Token"
+
+// Last known proxy settings of a single watched hive, as reported by /status
+type hiveStatus struct {
+	Hive         string `json:"hive"`
+	ProxyEnabled bool   `json:"proxy_enabled"`
+	ProxyServer  string `json:"proxy_server"`
+}
+
+// Response body of GET /status
+type monitorStatus struct {
+	Monitoring bool         `json:"monitoring"`
+	Hives      []hiveStatus `json:"hives"`
+
+	// Whether monitoring is held off because the session is locked
+	SessionLocked bool `json:"session_locked,omitempty"`
+
+	// How long the monitor has been running, and what it has seen since
+	UptimeSeconds int64       `json:"uptime_seconds"`
+	Stats         changeStats `json:"stats"`
+
+	// Most recent registry error and when it happened, left out if reading
+	// the registry has worked since
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Response body of the control endpoints
+type commandResult struct {
+	OK bool `json:"ok"`
+
+	// Why the command failed, empty when it succeeded
+	Error string `json:"error,omitempty"`
+}
+
+// Prints a status received from the main program instance
+func printStatus(payload []byte) error {
+	var status monitorStatus
+	err := json.Unmarshal(payload, &status)
+	if err != nil {
+		return err
+	}
+
+	if status.Monitoring {
+		fmt.Println("Monitoring proxy settings.")
+	} else if status.SessionLocked {
+		fmt.Println("Proxy monitor is paused while the session is locked.")
+	} else {
+		fmt.Println("Proxy monitor is turned off.")
+	}
+
+	for _, hive := range status.Hives {
+		if !hive.ProxyEnabled {
+			fmt.Printf("%s: proxy off\n", hive.Hive)
+			continue
+		}
+
+		servers := displayProxyServer(hive.ProxyServer)
+		fmt.Printf("%s: proxy on, %s\n", hive.Hive, servers)
+	}
+
+	uptime := time.Duration(status.UptimeSeconds) * time.Second
+	fmt.Printf("Uptime %s, %d changes (%d on / %d off / %d server-swap)\n",
+		uptime, status.Stats.Changes, status.Stats.Enabled, status.Stats.Disabled, status.Stats.ServerChanges)
+
+	if status.LastError != "" && status.LastErrorAt != nil {
+		ago := time.Since(*status.LastErrorAt).Round(time.Second)
+		fmt.Printf("Last error: %s (%s ago)\n", status.LastError, ago)
+	}
+
+	return nil
+}
+
+// Runs the HTTP server, an alternative to the named pipe for tools that would
+// rather scrape JSON than speak the pipe protocol, until the context is
+// cancelled
+func runHTTPServer(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, controller.Status())
+	})
+
+	// Anyone who can reach a non-loopback address could otherwise stop or
+	// quit the monitor, the token is the only thing keeping them out
+	loopback := isLoopbackAddr(addr)
+	if !loopback && currentConfig().HTTPToken == "" {
+		printWarning("http_addr isn't a loopback address and http_token isn't set, the control endpoints are turned off")
+	}
+
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/start", commandHandler(CMD_START, loopback))
+	mux.HandleFunc("/stop", commandHandler(CMD_STOP, loopback))
+	mux.HandleFunc("/reload", commandHandler(CMD_RELOAD, loopback))
+	mux.HandleFunc("/clearlog", commandHandler(CMD_CLEARLOG, loopback))
+	mux.HandleFunc("/quit", commandHandler(CMD_QUIT, loopback))
+
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	// Requests that are still being handled get to finish, for a while
+	returned := make(chan struct{})
+	defer close(returned)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-returned:
+			return
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			server.Close()
+		}
+	}()
+
+	printInfo("HTTP server listening on", addr)
+
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		printError("HTTP server failed:", err)
+	}
+}
+
+// Checks that a control request wasn't sent by a browser and carries the
+// http_token, if there is one. Browsers add Origin or Sec-Fetch-Site to
+// cross-site requests, which scripts and curl don't. Without a token, only a
+// server on a loopback address takes control requests, and any value of the
+// header is enough. Returns why the request was refused, empty if it's allowed
+func checkControlRequest(r *http.Request, loopback bool) string {
+	if r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != "" {
+		return "requests from web pages are not allowed"
+	}
+
+	sent := r.Header.Get(HTTP_TOKEN_HEADER)
+	if sent == "" {
+		return "missing " + HTTP_TOKEN_HEADER + " header"
+	}
+
+	token := currentConfig().HTTPToken
+	if token == "" {
+		if !loopback {
+			return "control endpoints need http_token when http_addr isn't a loopback address"
+		}
+
+		return ""
+	}
+
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		return "wrong " + HTTP_TOKEN_HEADER
+	}
+
+	return ""
+}
+
+// Reports whether the address only listens on loopback. An empty host
+// listens on every interface
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Creates a handler for POST requests that executes the given command
+func commandHandler(cmd byte, loopback bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		refused := checkControlRequest(r, loopback)
+		if refused != "" {
+			http.Error(w, refused, http.StatusForbidden)
+			return
+		}
+
+		// The quit command exits the process, so the response has to be sent
+		// before executing it. Quitting shuts the server down, which waits
+		// for this request, so it can't be waited for here
+		if cmd == CMD_QUIT {
+			writeJSON(w, commandResult{OK: true})
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+
+			go executeCommand(cmd)
+			return
+		}
+
+		code := executeCommand(cmd)
+		writeJSON(w, commandResult{OK: code == RESPONSE_OK, Error: responseCodeName(code)})
+	}
+}
+
+// Returns a name for a failed command's response code, or an empty string if
+// the command succeeded
+func responseCodeName(code byte) string {
+	switch code {
+	case RESPONSE_OK:
+		return ""
+	case RESPONSE_ALREADY_IN_STATE:
+		return "already_in_state"
+	case RESPONSE_REGISTRY_ERROR:
+		return "registry_error"
+	case RESPONSE_CONFIG_ERROR:
+		return "config_error"
+	case RESPONSE_WRITES_DISABLED:
+		return "writes_disabled"
+	case RESPONSE_ACCESS_DENIED:
+		return "access_denied"
+	default:
+		return "internal_error"
+	}
+}
+
+// Writes the value as the JSON response body
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		printError("Failed to write HTTP response:", err)
+	}
+}
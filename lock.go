@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/allan-simon/go-singleinstance"
+)
+
+// File access permissions of the PID file: we can read/write the file,
+// everyone else can only read it
+const PID_FILE_PERMISSIONS os.FileMode = 0644
+
+// Removes a lock file left behind by a main instance that no longer exists
+// and acquires it for this process.
+// If two instances try to take over at the same time, only one of them can
+// create the new lock file, the other one gets an error
+func takeOverStaleLock() (*os.File, error) {
+	pid, err := readLockPid()
+	if err == nil && pid != os.Getpid() && isProcessAlive(pid) {
+		return nil, fmt.Errorf("monitor process %d is running, but not responding", pid)
+	}
+
+	err = os.Remove(lockFileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return singleinstance.CreateLockFile(lockFileName)
+}
+
+// Reads the PID of the main instance from the lock file
+func readLockPid() (int, error) {
+	data, err := os.ReadFile(lockFileName)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Reads the PID of the main instance from the PID file
+func readPidFile() (int, error) {
+	data, err := os.ReadFile(pidFileName)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Writes the PID of this process to the PID file. Unlike the lock file, it's
+// never held open, so other programs can always read it
+func writePidFile() error {
+	return os.WriteFile(pidFileName, []byte(strconv.Itoa(os.Getpid())), PID_FILE_PERMISSIONS)
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Reports whether a process with the given PID is running. Signal 0 doesn't
+// do anything to the process, it only checks that it can be signalled
+func isProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	// The process exists, but belongs to a user we can't signal
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
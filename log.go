@@ -0,0 +1,499 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Values of the rotation setting. With daily rotation, every day is logged to
+// its own file, with the date added to the log file's name
+const ROTATION_NONE = "none"
+const ROTATION_DAILY = "daily"
+
+// File access permissions of the log file: we can read/write the file,
+// everyone else can only read it
+const LOG_FILE_PERMISSIONS os.FileMode = 0644
+
+// Date format used in the names of daily log files
+const LOG_DATE_FORMAT = "2006-01-02"
+
+// How many log entries can be waiting to be written before new ones are
+// dropped
+const LOG_BUFFER_SIZE = 256
+
+// A line waiting to be written to the log file, or a request to flush the
+// file to the disk or to clear it
+type logEntry struct {
+	time    time.Time
+	message string
+	sync    bool
+
+	// Number the line is written with, 0 when lines aren't numbered
+	sequence uint64
+
+	// Set for a request to clear the file, receives the result
+	clear chan error
+}
+
+// Guards writes to the log file, and swapping it out
+var logMutex sync.Mutex
+
+// The log file proxy changes are written to, shared by every watcher. The
+// file and the timestamp settings can be changed by reloading the config,
+// so they're only accessed with the logMutex held
+type monitorLog struct {
+	file            *os.File
+	timestampLayout string
+	timestampUTC    bool
+
+	// Log path from the config, which daily log files are named after
+	basePath string
+	rotation string
+	maxDays  int
+
+	// Whether daily log files are gzipped once a new day has started
+	compress bool
+
+	// Whether lines are numbered, and the number of the last one. Numbers
+	// are handed out when a line is queued, so a dropped line leaves a gap.
+	// Atomic, so queueing a line never waits for the logMutex
+	numbered     atomic.Bool
+	lastSequence atomic.Uint64
+
+	// Date of the currently open daily log file
+	day string
+
+	// Entries waiting for the writer goroutine. A slow disk only holds up
+	// the writer, never the watchers
+	entries chan logEntry
+
+	// How many entries were dropped because the buffer was full, reported
+	// once the writer catches up
+	dropped atomic.Int64
+
+	// Closed to stop the writer, which closes done once everything has been
+	// written
+	stop chan struct{}
+	done chan struct{}
+}
+
+// The log of the running monitor, nil until it has been opened
+var activeLog *monitorLog
+
+// Opens the log file from the config and makes it the active log
+func openMonitorLog(config Config) (*monitorLog, error) {
+	now := time.Now()
+
+	file, err := openLogFile(config.logFilePath(now))
+	if err != nil {
+		return nil, err
+	}
+
+	logFile := &monitorLog{
+		file:            file,
+		timestampLayout: config.timestampLayout(),
+		timestampUTC:    config.TimestampUTC,
+		basePath:        config.LogPath,
+		rotation:        config.Rotation,
+		maxDays:         config.MaxDays,
+		compress:        config.CompressRotated,
+		day:             now.Format(LOG_DATE_FORMAT),
+		entries:         make(chan logEntry, LOG_BUFFER_SIZE),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+
+	logFile.numbered.Store(config.LogSequence)
+
+	go logFile.run()
+
+	if logFile.rotation == ROTATION_DAILY {
+		pruneDailyLogs(logFile.basePath, logFile.maxDays, now)
+
+		if logFile.compress {
+			compressOldDailyLogs(logFile.basePath, now)
+		}
+	}
+
+	logMutex.Lock()
+	activeLog = logFile
+	logMutex.Unlock()
+
+	return logFile, nil
+}
+
+// Path of the log file
+func (l *monitorLog) name() string {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	return l.file.Name()
+}
+
+// Switches to the log file and timestamp format from the config. The file is
+// reopened even if its path hasn't changed, so after an external log rotator
+// has renamed it, writes go to a fresh file at the configured path again.
+// The new file is opened before the old one is closed, so nothing is lost if
+// it can't be
+func (l *monitorLog) apply(config Config) error {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	l.timestampLayout = config.timestampLayout()
+	l.timestampUTC = config.TimestampUTC
+	l.maxDays = config.MaxDays
+	l.compress = config.CompressRotated
+	l.numbered.Store(config.LogSequence)
+
+	now := time.Now()
+	path := config.logFilePath(now)
+
+	file, err := openLogFile(path)
+	if err != nil {
+		return err
+	}
+
+	l.file.Sync()
+	l.file.Close()
+	l.file = file
+	l.basePath = config.LogPath
+	l.rotation = config.Rotation
+	l.day = now.Format(LOG_DATE_FORMAT)
+
+	// Turning compression on with a reload takes care of the old files too
+	if l.rotation == ROTATION_DAILY && l.compress {
+		compressOldDailyLogs(l.basePath, now)
+	}
+	return nil
+}
+
+// With daily rotation, switches to a new log file when the date has changed
+// since the last write, and removes the files older than max_days. With
+// compress_rotated, the old file is compressed in the background. If the new
+// file can't be opened, the old one keeps being written to.
+// Expects the logMutex to be held
+func (l *monitorLog) rotate(now time.Time) {
+	if l.rotation != ROTATION_DAILY {
+		return
+	}
+
+	day := now.Format(LOG_DATE_FORMAT)
+	if day == l.day {
+		return
+	}
+
+	file, err := openLogFile(datedLogPath(l.basePath, now))
+	if err != nil {
+		printError("Failed to open the new day's log file:", err)
+		return
+	}
+
+	previousPath := l.file.Name()
+
+	l.file.Sync()
+	l.file.Close()
+	l.file = file
+	l.day = day
+
+	pruneDailyLogs(l.basePath, l.maxDays, now)
+
+	if l.compress {
+		compressLogInBackground(previousPath)
+	}
+}
+
+// Writes the queued entries to the file until the log is closed. Rotation,
+// syncing and publishing to -tail clients all happen here
+func (l *monitorLog) run() {
+	defer close(l.done)
+
+	for {
+		select {
+		case entry := <-l.entries:
+			l.write(entry)
+
+		case <-l.stop:
+			// Write whatever was still queued before stopping
+			for {
+				select {
+				case entry := <-l.entries:
+					l.write(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Writes a single entry to the file, along with a note about the entries
+// that were dropped before it
+func (l *monitorLog) write(entry logEntry) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	l.rotate(entry.time)
+
+	if entry.sync {
+		err := l.file.Sync()
+		if err != nil {
+			printError("Failed to sync log file:", err)
+		}
+		return
+	}
+
+	// On Windows the file isn't opened for appending, so it's rewound as
+	// well for the writes to carry on from the start
+	if entry.clear != nil {
+		err := l.file.Truncate(0)
+		if err == nil {
+			_, err = l.file.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			l.dropped.Store(0)
+			l.writeLine(entry.time, "log cleared")
+		}
+
+		entry.clear <- err
+		return
+	}
+
+	dropped := l.dropped.Swap(0)
+	if dropped > 0 {
+		l.writeLine(entry.time, fmt.Sprintf("%d log entries were dropped, the log file couldn't keep up", dropped))
+	}
+
+	message := entry.message
+	if entry.sequence > 0 {
+		message = fmt.Sprintf("#%04d %s", entry.sequence, message)
+	}
+
+	l.writeLine(entry.time, message)
+}
+
+// Formats and writes a line, expects the logMutex to be held
+func (l *monitorLog) writeLine(now time.Time, message string) {
+	if l.timestampUTC {
+		now = now.UTC()
+	}
+	line := now.Format(l.timestampLayout) + "\t" + escapeControlCharacters(message)
+
+	_, err := fmt.Fprintln(l.file, line)
+	if err != nil {
+		printError("Failed to write to log file:", err)
+	}
+
+	publishTailLine(line)
+}
+
+// Queues an entry for the writer, dropping it if the buffer is full. A
+// dropped sync doesn't lose anything, so only dropped lines are counted
+func (l *monitorLog) enqueue(entry logEntry) {
+	select {
+	case l.entries <- entry:
+	default:
+		if !entry.sync {
+			l.dropped.Add(1)
+		}
+	}
+}
+
+// Empties the log file and starts it over with a "log cleared" line. Goes
+// through the writer like every other entry, so lines queued before are
+// cleared as well and nothing is written while the file is being truncated.
+// The file stays open, truncating it from outside would leave the monitor
+// writing at its old offset
+func (l *monitorLog) clear() error {
+	closed := errors.New("log file is closed")
+	result := make(chan error, 1)
+
+	// The queue is buffered, so sending could still work once the writer
+	// has stopped, and nothing would ever answer
+	select {
+	case <-l.done:
+		return closed
+	default:
+	}
+
+	select {
+	case l.entries <- logEntry{time: time.Now(), clear: result}:
+	case <-l.done:
+		return closed
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-l.done:
+		// The writer may still have gotten to it while stopping
+		select {
+		case err := <-result:
+			return err
+		default:
+			return closed
+		}
+	}
+}
+
+// Makes sure everything that was logged ends up on disk and closes the file
+func (l *monitorLog) close() {
+	close(l.stop)
+	<-l.done
+
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	l.file.Sync()
+	l.file.Close()
+}
+
+// Adds the date to the name of the log file, so proxy-monitor.log becomes
+// proxy-monitor-2024-06-01.log
+func datedLogPath(logPath string, day time.Time) string {
+	ext := filepath.Ext(logPath)
+	return strings.TrimSuffix(logPath, ext) + "-" + day.Format(LOG_DATE_FORMAT) + ext
+}
+
+// Removes the daily log files that are more than maxDays days old, compressed
+// or not. 0 keeps every file
+func pruneDailyLogs(logPath string, maxDays int, now time.Time) {
+	if maxDays <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(logPath)
+	prefix := filepath.Base(strings.TrimSuffix(logPath, ext)) + "-"
+
+	entries, err := os.ReadDir(filepath.Dir(logPath))
+	if err != nil {
+		printError("Failed to list old log files:", err)
+		return
+	}
+
+	today, _ := time.ParseInLocation(LOG_DATE_FORMAT, now.Format(LOG_DATE_FORMAT), time.Local)
+	oldest := today.AddDate(0, 0, -maxDays)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		uncompressed := strings.TrimSuffix(name, COMPRESSED_LOG_EXT)
+		if entry.IsDir() || !strings.HasPrefix(uncompressed, prefix) || !strings.HasSuffix(uncompressed, ext) {
+			continue
+		}
+
+		// Skip files that just happen to start with the same name
+		date := strings.TrimSuffix(strings.TrimPrefix(uncompressed, prefix), ext)
+		day, err := time.ParseInLocation(LOG_DATE_FORMAT, date, time.Local)
+		if err != nil || !day.Before(oldest) {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(filepath.Dir(logPath), name))
+		if err != nil {
+			printError("Failed to remove old log file:", err)
+		}
+	}
+}
+
+// Path of the file currently being logged to, which with daily rotation isn't
+// the log path from the config
+func currentLogPath() string {
+	logMutex.Lock()
+	logFile := activeLog
+	logMutex.Unlock()
+
+	if logFile == nil {
+		return currentConfig().logFilePath(time.Now())
+	}
+
+	return logFile.name()
+}
+
+// Opens the log file for appending, creating it and its directory if needed.
+// A symlink or junction in place of the file is refused
+func openLogFile(logPath string) (*os.File, error) {
+	dirErr := os.MkdirAll(filepath.Dir(logPath), os.ModePerm)
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	return openLogFileNoFollow(logPath)
+}
+
+// Checks that the log file can be opened for writing, so that a bad path is
+// reported at startup rather than when the first change is logged
+func validateLogPath(logPath string) error {
+	// Only happens when the data directory couldn't be found
+	if logPath == "" {
+		return errors.New("no log path set, and the data directory couldn't be found")
+	}
+
+	logFile, err := openLogFile(logPath)
+	if err != nil {
+		return err
+	}
+
+	return logFile.Close()
+}
+
+// Queues a timestamped line for the log file. The line is written in the
+// background, so a slow disk doesn't hold up the caller
+func writeLogEntry(logFile *monitorLog, message string) {
+	entry := logEntry{time: time.Now(), message: message}
+	if logFile.numbered.Load() {
+		entry.sequence = logFile.lastSequence.Add(1)
+	}
+
+	logFile.enqueue(entry)
+}
+
+// Queues a line for the log of the running monitor, for the parts of it that
+// don't have the log at hand. Does nothing before the log has been opened
+func writeActiveLogEntry(message string) {
+	logMutex.Lock()
+	logFile := activeLog
+	logMutex.Unlock()
+
+	if logFile != nil {
+		writeLogEntry(logFile, message)
+	}
+}
+
+// Flushes the log file to the disk, once everything queued before has been
+// written
+func syncLogFile(logFile *monitorLog) {
+	logFile.enqueue(logEntry{time: time.Now(), sync: true})
+}
+
+// Escapes newlines, tabs and other control characters, like \n, \t or \x1b,
+// and replaces invalid UTF-8. Messages can contain values from the registry,
+// and a newline in one would otherwise start a fake log entry of its own
+func escapeControlCharacters(message string) string {
+	message = strings.ToValidUTF8(message, "\uFFFD")
+
+	if strings.IndexFunc(message, unicode.IsControl) == -1 {
+		return message
+	}
+
+	var escaped strings.Builder
+	for _, r := range message {
+		if !unicode.IsControl(r) {
+			escaped.WriteRune(r)
+			continue
+		}
+
+		// Quoting escapes the rune the way Go source would
+		quoted := strconv.QuoteRune(r)
+		escaped.WriteString(quoted[1 : len(quoted)-1])
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestEscapeControlCharacters(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "nothing to escape", message: "proxy on, 10.0.0.1:8080", want: "proxy on, 10.0.0.1:8080"},
+		{name: "newline", message: "proxy:80\nproxy off", want: `proxy:80\nproxy off`},
+		{name: "carriage return", message: "proxy:80\r\n", want: `proxy:80\r\n`},
+		{name: "tab", message: "proxy:80\tproxy off", want: `proxy:80\tproxy off`},
+		{name: "escape sequence", message: "\x1b[31mproxy:80", want: `\x1b[31mproxy:80`},
+		{name: "null", message: "proxy:80\x00", want: `proxy:80\x00`},
+		{name: "invalid UTF-8", message: "proxy\xff:80", want: "proxy�:80"},
+		{name: "unicode", message: "prøxy:80 …", want: "prøxy:80 …"},
+		{name: "empty", message: "", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := escapeControlCharacters(test.message)
+			if got != test.want {
+				t.Errorf("escapeControlCharacters(%q) = %q, want %q", test.message, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Opens the log file for appending without following a symlink at its path,
+// which someone else could have put there to have the monitor write to a
+// file of their choosing. Files left world-writable by older versions get
+// their permissions tightened, if they're this user's
+func openLogFileNoFollow(logPath string) (*os.File, error) {
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY|syscall.O_NOFOLLOW, LOG_FILE_PERMISSIONS)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, fmt.Errorf("%s is a symbolic link, refusing to log to it", logPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err == nil && info.Mode().IsRegular() && info.Mode().Perm()&^LOG_FILE_PERMISSIONS != 0 {
+		file.Chmod(LOG_FILE_PERMISSIONS)
+	}
+
+	return file, nil
+}
@@ -0,0 +1,59 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Opens the log file without following a symlink or junction at its path,
+// which someone else could have put there to have the monitor write to a
+// file of their choosing. The reparse point itself is opened instead, and
+// then refused.
+// The file isn't opened for appending, since truncating it for -clearlog
+// needs write access to its data, so it's positioned at its end instead.
+// Deleting is shared as well, so external log rotators can rename it
+func openLogFileNoFollow(logPath string) (*os.File, error) {
+	path, err := windows.UTF16PtrFromString(logPath)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: logPath, Err: err}
+	}
+
+	handle, err := windows.CreateFile(
+		path,
+		windows.GENERIC_WRITE|windows.FILE_READ_ATTRIBUTES,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil,
+		windows.OPEN_ALWAYS,
+		windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_OPEN_REPARSE_POINT,
+		0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: logPath, Err: err}
+	}
+
+	var info windows.ByHandleFileInformation
+	err = windows.GetFileInformationByHandle(handle, &info)
+	if err != nil {
+		windows.CloseHandle(handle)
+		return nil, &os.PathError{Op: "stat", Path: logPath, Err: err}
+	}
+
+	if info.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0 {
+		windows.CloseHandle(handle)
+		return nil, fmt.Errorf("%s is a symbolic link or junction, refusing to log to it", logPath)
+	}
+
+	file := os.NewFile(uintptr(handle), logPath)
+
+	_, err = file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -1,31 +1,34 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
-	// Named pipes library
-	"github.com/Microsoft/go-winio"
-
 	// Single instance library
 	"github.com/allan-simon/go-singleinstance"
-
-	// System tray library and their example icon
-	"github.com/getlantern/systray"
-	"github.com/getlantern/systray/example/icon"
-
-	// Registry access API
-	"golang.org/x/sys/windows/registry"
 )
 
 // Name of the process lock file
 const LOCK_FILE = "monitor.lock"
 
-// Name of the named pipe used to communicate between the main monitor
-// process and newer instances
-const PIPE_FILE = `\\.\pipe\proxymonitor`
+// Name of the file the main instance writes its PID to, so scripts can find it
+const PID_FILE = "monitor.pid"
+
+// Lock file, pipe and config file names actually used, which differ from the
+// defaults when an instance name is given, so several monitors can run side
+// by side
+var lockFileName = LOCK_FILE
+var pipeName = pipeNameFor("")
+var configFileName = CONFIG_FILE
+var pidFileName = PID_FILE
+var stateFileName = STATE_FILE
 
 // Command constants, used to internally represent the
 // commands stop, start and quit
@@ -34,374 +37,995 @@ const NO_COMMAND byte = 0
 const CMD_STOP byte = 1
 const CMD_QUIT byte = 2
 const CMD_START byte = 3
+const CMD_RESTART byte = 4
+const CMD_HISTORY byte = 5
+const CMD_STATUS byte = 6
+const CMD_PAUSE byte = 7
+const CMD_TAIL byte = 8
+
+// Carried out by the process it was given to, never sent over the pipe
+const CMD_ONCE byte = 9
+
+const CMD_RELOAD byte = 10
+
+// Also carried out locally, only reads the PID file and pings the pipe
+const CMD_PING byte = 11
+
+// Prints the config in effect. Asks the main instance for its live config
+// over the pipe when there is one, so it's also sent between processes
+const CMD_CONFIG byte = 12
+
+// Writes the history to a CSV file. Local, it sends CMD_HISTORY to get it
+const CMD_EXPORT byte = 13
+
+// Checks the environment the monitor needs, local as well
+const CMD_DOCTOR byte = 14
+
+// Has the main instance empty its log file, sent over the pipe
+const CMD_CLEARLOG byte = 15
+
+// Have the main instance change the proxy settings in the registry, sent over
+// the pipe so the watchers know the change was the monitor's own. Carried out
+// locally when no monitor is running
+const CMD_ENABLE_PROXY byte = 16
+const CMD_DISABLE_PROXY byte = 17
+
+// Exit code when the command line arguments can't be parsed, the same one
+// most command line tools use
+const EXIT_USAGE = 2
+
+// Printed when the command line arguments can't be parsed
+const USAGE = `Usage: proxy-monitor [command]
+
+Commands:
+  -start              Start monitoring proxy settings (default)
+  -stop               Stop monitoring proxy settings
+  -restart            Re-log the current proxy settings as a fresh baseline
+  -reload             Re-read the config file without restarting the monitor
+  -pause <duration>   Stop monitoring and resume after the duration, like 30s
+  -status             Show whether monitoring is on and the current proxy settings
+  -history            Show the most recent proxy changes
+  -tail               Print proxy changes as they happen, until Ctrl+C
+  -once               Print the current proxy settings from the registry and exit
+  -ping               Check whether the monitor is running and responding
+  -config             Print the config in effect as JSON
+  -export <file>      Write the recent proxy changes to a CSV file
+  -clearlog           Empty the log file without stopping the monitor
+  -enable-proxy       Turn the proxy on with the server given by -server, needs
+                      allow_writes in the config
+  -disable-proxy      Turn the proxy off, needs allow_writes in the config
+  -doctor             Check that the monitor has the permissions it needs
+  -quit               Close the monitor program
+  -version            Print the program version
+  -install-service    Install the monitor as a Windows service
+  -uninstall-service  Uninstall the Windows service
+
+Options:
+  -verbose            Print every registry poll, when starting the monitor
+  -watch-file         Also watch the contents of a local PAC file, when starting
+                      the monitor
+  -no-tray            Run without the tray icon, when starting the monitor
+  -stdin              Also read commands like stop or status from stdin, one
+                      per line, when starting the monitor
+  -simulate           Make up proxy changes on a timer instead of reading the
+                      registry, for demos, when starting the monitor
+  -quiet              Only print errors and warnings, when starting the monitor
+  -no-color           Don't color the console output
+  -instance <name>    Run or talk to a separate, named monitor instance
+  -server <host:port> Proxy server to turn on with -enable-proxy
+  -json               Print the output of -once as JSON
+  -redact             Hide secrets like the webhook URL in the output of -config`
+
+// Set when the main instance was started with -verbose, which keeps verbose
+// output on when the config is reloaded
+var verboseOption bool
+
+// Set when the main instance was started with -watch-file, which keeps the
+// PAC file watched when the config is reloaded
+var watchFileOption bool
+
+// A command parsed from the command line, along with its arguments
+type command struct {
+	id byte
+
+	// How long to pause monitoring for, only used by CMD_PAUSE
+	duration time.Duration
+
+	// Print every registry poll, only used when starting the main instance
+	verbose bool
+
+	// Watch the PAC file's contents, only used when starting the main
+	// instance
+	watchFile bool
+
+	// Don't create the tray icon, only used when starting the main instance
+	noTray bool
+
+	// Also read commands from stdin, only used when starting the main
+	// instance
+	stdin bool
+
+	// Make up proxy changes instead of reading the registry, only used when
+	// starting the main instance
+	simulate bool
+
+	// Only print errors and warnings, only used when starting the main
+	// instance
+	quiet bool
+
+	// Don't color the console output
+	noColor bool
+
+	// Name of the monitor instance to run or talk to, empty for the default
+	instance string
+
+	// Print the output as JSON, only used by CMD_ONCE
+	json bool
+
+	// Hide secrets in the printed config, only used by CMD_CONFIG
+	redact bool
+
+	// File to write the history to, only used by CMD_EXPORT
+	exportPath string
+
+	// Proxy server to turn on, only used by CMD_ENABLE_PROXY
+	server string
+}
+
+// Parses the program's own command line arguments
+func parseCommandLine() (command, error) {
+	return parseCommand(os.Args[1:])
+}
+
+// Parses command line arguments, without the program name, into one of the
+// command constants, the command's arguments and any options that were given
+// along with it
+func parseCommand(args []string) (command, error) {
+	cmd := command{id: NO_COMMAND}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// Options can be given along with any command
+		switch arg {
+		case "-verbose":
+			cmd.verbose = true
+			continue
+
+		case "-json":
+			cmd.json = true
+			continue
+
+		case "-watch-file":
+			cmd.watchFile = true
+			continue
+
+		case "-redact":
+			cmd.redact = true
+			continue
+
+		case "-no-tray":
+			cmd.noTray = true
+			continue
+
+		case "-stdin":
+			cmd.stdin = true
+			continue
+
+		case "-simulate":
+			cmd.simulate = true
+			continue
+
+		case "-quiet":
+			cmd.quiet = true
+			continue
+
+		case "-no-color":
+			cmd.noColor = true
+			continue
+
+		case "-instance":
+			if i+1 >= len(args) {
+				return command{id: NO_COMMAND}, fmt.Errorf("-instance requires a name")
+			}
+
+			i++
+			if !isValidInstanceName(args[i]) {
+				return command{id: NO_COMMAND}, fmt.Errorf("invalid instance name: %s", args[i])
+			}
+
+			cmd.instance = args[i]
+			continue
+
+		case "-server":
+			if i+1 >= len(args) {
+				return command{id: NO_COMMAND}, fmt.Errorf("-server requires a proxy server, like -server proxy.corp:8080")
+			}
 
-// Global variable that controls the state of the listener
-var listenerEnabled bool = true
+			i++
+			err := validateProxyServerArg(args[i])
+			if err != nil {
+				return command{id: NO_COMMAND}, err
+			}
 
-// Parses the command line argument into one of the command constants
-func parseCommand() (byte, error) {
-	args := os.Args
-	len := len(args)
+			cmd.server = args[i]
+			continue
+		}
 
-	if len < 2 {
-		return NO_COMMAND, nil
+		// The command is looked up first, so a misspelled one after another
+		// command is still reported as unknown
+		hadCommand := cmd.id != NO_COMMAND
+
+		switch arg {
+		case "-stop":
+			cmd.id = CMD_STOP
+		case "-start":
+			cmd.id = CMD_START
+		case "-quit":
+			cmd.id = CMD_QUIT
+		case "-restart":
+			cmd.id = CMD_RESTART
+		case "-reload":
+			cmd.id = CMD_RELOAD
+		case "-history":
+			cmd.id = CMD_HISTORY
+		case "-status":
+			cmd.id = CMD_STATUS
+		case "-tail":
+			cmd.id = CMD_TAIL
+		case "-once":
+			cmd.id = CMD_ONCE
+		case "-ping":
+			cmd.id = CMD_PING
+		case "-config":
+			cmd.id = CMD_CONFIG
+		case "-doctor":
+			cmd.id = CMD_DOCTOR
+		case "-clearlog":
+			cmd.id = CMD_CLEARLOG
+		case "-enable-proxy":
+			cmd.id = CMD_ENABLE_PROXY
+		case "-disable-proxy":
+			cmd.id = CMD_DISABLE_PROXY
+		case "-pause":
+			if i+1 >= len(args) {
+				return command{id: NO_COMMAND}, fmt.Errorf("-pause requires a duration, like -pause 30s")
+			}
+
+			i++
+			duration, err := time.ParseDuration(args[i])
+			if err != nil || duration <= 0 {
+				return command{id: NO_COMMAND}, fmt.Errorf("invalid pause duration: %s", args[i])
+			}
+
+			cmd.id = CMD_PAUSE
+			cmd.duration = duration
+		case "-export":
+			if i+1 >= len(args) {
+				return command{id: NO_COMMAND}, fmt.Errorf("-export requires a file name, like -export history.csv")
+			}
+
+			i++
+			cmd.id = CMD_EXPORT
+			cmd.exportPath = args[i]
+		default:
+			return command{id: NO_COMMAND}, fmt.Errorf("unknown command: %s", arg)
+		}
+
+		if hadCommand {
+			return command{id: NO_COMMAND}, fmt.Errorf("only one command can be given: %s", arg)
+		}
 	}
 
-	arg := os.Args[1]
+	// Options can come before the command, so these are only checked once
+	// everything has been parsed
+	if cmd.id == CMD_ENABLE_PROXY && cmd.server == "" {
+		return command{id: NO_COMMAND}, fmt.Errorf("-enable-proxy requires -server, like -enable-proxy -server proxy.corp:8080")
+	}
 
-	switch arg {
-	case "-stop":
-		return CMD_STOP, nil
-	case "-start":
-		return CMD_START, nil
-	case "-quit":
-		return CMD_QUIT, nil
-	default:
-		return NO_COMMAND, fmt.Errorf("unknown command: %s", arg)
+	if cmd.id != CMD_ENABLE_PROXY && cmd.server != "" {
+		return command{id: NO_COMMAND}, fmt.Errorf("-server can only be given with -enable-proxy")
+	}
+
+	return cmd, nil
+}
+
+// Instance names end up in file and pipe names, so only letters, digits,
+// dashes and underscores are allowed
+func isValidInstanceName(name string) bool {
+	if name == "" {
+		return false
 	}
+
+	for _, r := range name {
+		valid := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_'
+		if !valid {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Derives the lock file, pipe and config file names from the instance name,
+// so a client given the same name always talks to the right main instance,
+// and each instance can monitor something else
+func useInstance(name string) {
+	if name == "" {
+		lockFileName = LOCK_FILE
+		pipeName = pipeNameFor("")
+		configFileName = CONFIG_FILE
+		pidFileName = PID_FILE
+		stateFileName = STATE_FILE
+		return
+	}
+
+	lockFileName = "monitor-" + name + ".lock"
+	pipeName = pipeNameFor(name)
+	configFileName = "config-" + name + ".json"
+	pidFileName = "monitor-" + name + ".pid"
+	stateFileName = "proxy-state-" + name + ".json"
 }
 
 // When several instances of this process are started, the oldest one is the
 // process that actually does the monitoring, it starts up in a different way,
 // the only purpose of the newer instances is to communicate a command to the
-// main instance
-func clientMain() {
-	// Connect to the named pipe
-	f, err := winio.DialPipe(PIPE_FILE, nil)
+// main instance.
+// Returns false if there was no main instance to send the command to
+func clientMain(parsedCmd command) bool {
+	// Connect to the named pipe. If the main instance is stuck, fail after a
+	// timeout instead of hanging forever
+	f, err := dialControlWithRetry(pipeName)
 	if err != nil {
-		fmt.Println("Failed to dial to pipe", err)
-		return
+		if isTimeout(err) {
+			printError("Monitor is not responding.")
+			return true
+		}
+
+		// Nothing was listening on the pipe for any of the attempts, so the
+		// main instance is gone
+		if isNoListener(err) {
+			return false
+		}
+
+		printErrorf("Monitor not responding after %d attempts: %v\n", PIPE_DIAL_ATTEMPTS, err)
+		return true
 	}
 
 	defer f.Close()
 
-	// Parse the command line argument, which will be sent to
-	// the main program instance
-	parsedCmd, err := parseCommand()
+	// Started a second time without a command, like when the shortcut is
+	// clicked twice. There's nothing to send, the monitor is just running
+	if parsedCmd.id == NO_COMMAND {
+		printAlreadyRunning()
+		return true
+	}
+
+	// The same goes for sending the command and reading the response
+	err = f.SetDeadline(time.Now().Add(PIPE_TIMEOUT))
 	if err != nil {
-		fmt.Println("Failed to parse command line arguments:", err)
-		return
+		printError("Failed to set pipe timeout:", err)
 	}
 
-	// Create a buffer that will be used to store the information sent between
-	// this instance and the main one
-	buf := make([]byte, 1)
-	buf[0] = parsedCmd
+	// Send the command and, for the commands that get one, read the response
+	// from the main program instance. It will usually be one of the response
+	// codes, depending on if the command was carried out successfully
+	response, err := exchangeCommand(f, parsedCmd)
+	if err != nil {
+		if isTimeout(err) {
+			printError("Monitor is not responding.")
+			return true
+		}
 
-	// Send the command
-	_, err = f.Write(buf)
+		printError("Failed to talk to main program instance:", err)
+		return true
+	}
 
-	if err != nil {
-		fmt.Println("Failed to write bytes", err)
-		return
+	// The main instance keeps the connection open and streams every new log
+	// line over it, for as long as the user wants
+	if parsedCmd.id == CMD_TAIL {
+		f.SetDeadline(time.Time{})
+		printTail(f)
+		return true
 	}
 
 	// When a QUIT command is sent, the main process exits without sending a
-	// response, so don't try to read it.
-	if parsedCmd == CMD_QUIT {
-		return
+	// response
+	if parsedCmd.id == CMD_QUIT {
+		return true
 	}
 
-	// Read the response from the main program instance, it will always be either
-	// a 0 or 1, depending on if the command was carried out successfully
-	_, err = f.Read(buf)
+	if len(response) == 0 {
+		fmt.Println("Main program instance sent an empty response")
+		return true
+	}
+
+	// The history and status commands respond with data, rather than a
+	// response code. Data is always longer than a single byte, so a single
+	// byte means the command failed
+	if len(response) > 1 {
+		switch parsedCmd.id {
+		case CMD_HISTORY:
+			err = printHistory(response)
+			if err != nil {
+				printError("Failed to read history from main program instance:", err)
+			}
+			return true
+
+		case CMD_STATUS:
+			err = printStatus(response)
+			if err != nil {
+				printError("Failed to read status from main program instance:", err)
+			}
+			return true
+		}
+	}
+
+	fmt.Println(responseMessage(parsedCmd, response[0]))
+	return true
+}
+
+// Tells the user the monitor is already running, with its PID from the PID
+// file, or the lock file if that's missing
+func printAlreadyRunning() {
+	pid, err := readPidFile()
 	if err != nil {
-		fmt.Println("Failed to read response from main program instance:", err)
+		pid, err = readLockPid()
+	}
+
+	if err != nil {
+		fmt.Println("proxy-monitor is already running")
 		return
 	}
 
-	// This part just takes the 0 or 1 reply, turns it into a boolean and then
-	// prints a message corresponding to the command that was issued and if it
-	// was successful
-	success := buf[0] == 1
-	var message string
+	fmt.Printf("proxy-monitor is already running (pid %d)\n", pid)
+}
+
+// Turns the response code sent by the main program instance into a message
+// telling the user what happened
+func responseMessage(cmd command, code byte) string {
+	switch code {
+	case RESPONSE_REGISTRY_ERROR:
+		return "Monitor failed to read the proxy settings from the registry, see its output for details."
+	case RESPONSE_INTERNAL_ERROR:
+		return "Monitor failed to carry out the command, see its output for details."
+	case RESPONSE_CONFIG_ERROR:
+		return "Monitor failed to reload the config file and kept the old one, see its output for details."
+	case RESPONSE_WRITES_DISABLED:
+		return "Changing the proxy settings isn't allowed, set allow_writes to true in the config first."
+	case RESPONSE_ACCESS_DENIED:
+		return "The monitor runs as a service, which doesn't change the proxy settings for other programs. Stop the service first."
+	case RESPONSE_OK, RESPONSE_ALREADY_IN_STATE:
+	default:
+		return fmt.Sprintf("Monitor sent an unknown response: %d", code)
+	}
 
-	switch parsedCmd {
+	success := code == RESPONSE_OK
+
+	switch cmd.id {
 	case CMD_START:
 		if success {
-			message = "Started monitoring proxy settings."
-		} else {
-			message = "Already monitoring proxy settings."
+			return "Started monitoring proxy settings."
 		}
+		return "Already monitoring proxy settings."
 	case CMD_STOP:
 		if success {
-			message = "Stopped monitoring proxy settings"
-		} else {
-			message = "Proxy monitor is already turned off."
+			return "Stopped monitoring proxy settings"
 		}
+		return "Proxy monitor is already turned off."
 	case CMD_QUIT:
 		// QUIT command can never fail
-		message = "Quitting monitor program..."
+		return "Quitting monitor program..."
+	case CMD_RESTART:
+		return "Reset the monitor, the current proxy settings will be logged again."
+	case CMD_RELOAD:
+		return "Reloaded the config file."
+	case CMD_CLEARLOG:
+		return "Cleared the log file."
+	case CMD_ENABLE_PROXY:
+		if success {
+			return fmt.Sprintf("Turned the proxy on, using %s.", cmd.server)
+		}
+		return fmt.Sprintf("The proxy is already on, using %s.", cmd.server)
+	case CMD_DISABLE_PROXY:
+		if success {
+			return "Turned the proxy off."
+		}
+		return "The proxy is already off."
+	case CMD_PAUSE:
+		if success {
+			return fmt.Sprintf("Paused monitoring proxy settings for %s.", cmd.duration)
+		}
+		return "Proxy monitor is already turned off."
 	default:
-		return
+		return "Done."
 	}
-
-	fmt.Println(message)
 }
 
 // As stated above in the clientMain() comment, the main program instance starts
 // up differently than newer instances. This is the entry point for the first
 // instance of the program
-func serverMain() {
-	cmd, err := parseCommand()
+func serverMain(cmd command) {
+	// Just stop right away
+	if cmd.id == CMD_QUIT {
+		return
+	}
+
+	verboseOption = cmd.verbose
+	simulateOption = cmd.simulate
+
+	config := loadConfig()
+	config.Verbose = config.Verbose || verboseOption
+	watchFileOption = cmd.watchFile
+	config.WatchPacFile = config.WatchPacFile || watchFileOption
+	setCurrentConfig(config)
 
+	err := validateLogPath(config.logFilePath(time.Now()))
 	if err != nil {
-		fmt.Println("Failed to read command line arguments", err)
+		printErrorf("Log file %s is not writable: %v\n", config.LogPath, err)
+		printError("Set", LOG_DIR_ENV, "or log_path in the config to a writable location.")
+		return
 	}
 
-	// Just stop right away
-	if cmd == CMD_QUIT {
+	err = startControlServers(config)
+	if err != nil {
+		printError("Exiting, this monitor wouldn't be reachable:", err)
+		printError("The lock file and the pipe are out of sync, quit the other monitor with -quit first.")
 		return
 	}
 
+	// Only written once the pipe is known to be this monitor's, so the other
+	// monitor's PID file is left alone above
+	err = writePidFile()
+	if err != nil {
+		printError("Failed to write PID file:", err)
+	} else {
+		onShutdown(func() { os.Remove(pidFileName) })
+	}
+
+	// Headless runs, like over a remote session, have nowhere to show the
+	// tray icon. Monitoring works the same without it
+	if config.Tray && !cmd.noTray {
+		startWorker(func() { createSystemTrayIcon(rootContext) })
+	}
+
+	// Not a worker, a read from stdin can't be cancelled
+	if cmd.stdin {
+		go listenToStdin()
+	}
+
+	// Always watched, so pause_when_locked can be turned on with a reload
+	startWorker(func() { watchSessionLock(rootContext) })
+
+	// The controller starts out monitoring, so only the commands that turn
+	// it off need carrying out
+	switch cmd.id {
+	case CMD_STOP:
+		controller.Stop()
+	case CMD_PAUSE:
+		controller.Pause(cmd.duration)
+	default:
+		printInfo("Now listening to proxy changes")
+	}
+
+	listenToProxyChanges(rootContext, config)
+}
+
+// Starts everything that lets the monitor be controlled from the outside,
+// shared by the interactive and service modes. Returns an error when another
+// monitor is already listening on the pipe, despite this one holding the
+// lock file, in which case this one shouldn't keep running
+func startControlServers(config Config) error {
 	// Start up the named pipe and listen to commands from other
 	// instances of this program
-	go listenToNamedPipe()
-	go createSystemTrayIcon()
+	l, err := listenControl(pipeName)
+	if isPipeInUse(err) {
+		return fmt.Errorf("another monitor is already listening on %s: %w", pipeName, err)
+	}
 
-	if cmd == NO_COMMAND || cmd == CMD_START {
-		startListening()
+	if err != nil {
+		printError("Failed to listen to pipe!", err)
+	} else {
+		startWorker(func() { listenToNamedPipe(rootContext, l) })
 	}
 
-	listenToProxyChanges()
-}
+	if config.HTTPEnabled {
+		startWorker(func() { runHTTPServer(rootContext, config.HTTPAddr) })
+	}
 
-func createSystemTrayIcon() {
-	systray.Run(
-		func() {
-			systray.SetIcon(icon.Data)
-			systray.SetTitle("Proxy Monitor")
-
-			start := systray.AddMenuItem("Start", "Start monitoring")
-			stop := systray.AddMenuItem("Stop", "Stop monitoring")
-			quit := systray.AddMenuItem("Quit", "Quit monitoring")
-
-			go func() {
-				for {
-					select {
-					case <-start.ClickedCh:
-						if !listenerEnabled {
-							startListening()
-						}
-
-					case <-stop.ClickedCh:
-						if listenerEnabled {
-							stopListening()
-						}
-
-					case <-quit.ClickedCh:
-						os.Exit(0)
-					}
-				}
-			}()
-		},
-		nil)
+	return nil
 }
 
-func openLogFile() (*os.File, error) {
-	logDir := filepath.Join(os.Getenv("appdata"), "proxy-monitor")
-	dirErr := os.MkdirAll(logDir, os.ModePerm)
-	if dirErr != nil {
-		return nil, dirErr
+// Listens to messages from other instances of this program, until the
+// context is cancelled
+func listenToNamedPipe(ctx context.Context, l net.Listener) {
+	// The listener can be replaced below, so cancelling has to close
+	// whichever one is current, and make sure it isn't replaced afterwards.
+	// The same goes for the connections that are being handled
+	var listenerMutex sync.Mutex
+	active := map[net.Conn]bool{}
+	closing := false
+
+	returned := make(chan struct{})
+	defer close(returned)
+	defer func() { l.Close() }()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-returned:
+			return
+		}
+
+		listenerMutex.Lock()
+		defer listenerMutex.Unlock()
+
+		closing = true
+		for conn := range active {
+			conn.Close()
+		}
+		l.Close()
+		closeTailSubscribers()
+	}()
+
+	// Nested function that keeps track of a connection while it's handled
+	var track = func(conn net.Conn, handling bool) {
+		listenerMutex.Lock()
+		defer listenerMutex.Unlock()
+
+		if handling {
+			active[conn] = true
+		} else {
+			delete(active, conn)
+		}
 	}
 
-	// File access permissions: We can read/write the file,
-	// Everyone can read/write the file
-	var permissions os.FileMode = 0666
+	backoff := PIPE_ACCEPT_BACKOFF_MIN
+	failures := 0
 
-	logPath := filepath.Join(logDir, "proxy-monitor.log")
-	logFile, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, permissions)
+	for {
+		conn, err := l.Accept()
 
-	return logFile, err
-}
+		if err != nil {
+			listenerMutex.Lock()
+			stopped := closing
+			listenerMutex.Unlock()
+
+			// Closed by the shutdown, not an actual failure
+			if stopped {
+				return
+			}
+
+			printError("Failed to read pipe input", err)
+
+			// Don't spin if the listener keeps failing
+			time.Sleep(backoff)
+			backoff = min(backoff*2, PIPE_ACCEPT_BACKOFF_MAX)
+			failures++
+
+			// A closed listener never accepts anything again, and one that
+			// keeps failing probably won't either, so replace it
+			if errors.Is(err, errListenerClosed) || failures >= PIPE_ACCEPT_MAX_FAILURES {
+				listenerMutex.Lock()
+				if closing {
+					listenerMutex.Unlock()
+					return
+				}
+
+				// Only one listener can own the pipe name, so the old one
+				// has to be closed first
+				l.Close()
+				newListener, err := listenControl(pipeName)
+				if err != nil {
+					printError("Failed to recreate pipe listener:", err)
+				} else {
+					printInfo("Recreated pipe listener")
+					l = newListener
+					failures = 0
+				}
+				listenerMutex.Unlock()
+			}
+
+			continue
+		}
 
-// Detects changes in a loop in the windows registry
-func listenToProxyChanges() {
-	const keypath = `SOFTWARE\Microsoft\Windows\CurrentVersion\Internet Settings`
+		backoff = PIPE_ACCEPT_BACKOFF_MIN
+		failures = 0
 
-	// Get a HANDLE for the key to monitor
-	key, err := registry.OpenKey(registry.CURRENT_USER, keypath, registry.QUERY_VALUE)
+		// Every client gets its own goroutine, so a slow one doesn't hold up
+		// the others. The controller still carries out one command at a time
+		track(conn, true)
+		go func() {
+			defer track(conn, false)
+			handlePipeConnection(conn)
+		}()
+	}
+}
 
+// Reads a single command from a client connection, carries it out and sends
+// back the response
+func handlePipeConnection(conn net.Conn) {
+	// A client that never sends its command shouldn't keep the goroutine
+	// around forever
+	err := conn.SetDeadline(time.Now().Add(PIPE_TIMEOUT))
 	if err != nil {
-		fmt.Println("Error opening registry key", err)
+		printError("Failed to set pipe timeout:", err)
+	}
+
+	request, err := readFrame(conn)
+
+	// Connected and closed without sending anything, which is how a starting
+	// monitor checks whether the pipe is still in use
+	if errors.Is(err, io.EOF) {
+		conn.Close()
 		return
 	}
 
-	defer key.Close()
+	if err != nil {
+		printError("Failed to read", err)
+		conn.Close()
+		return
+	}
 
-	logFile, err := openLogFile()
+	// Get the command that was read and execute it
+	cmd, err := decodeCommand(request)
 	if err != nil {
-		fmt.Println("Failed to open log file:", err)
+		printError("Received an invalid command:", err)
+		conn.Close()
+		return
+	}
+
+	// Tail clients keep their connection open to receive log lines, so it's
+	// not closed here, and it can stay idle for as long as it likes
+	if cmd.id == CMD_TAIL {
+		conn.SetDeadline(time.Time{})
+		addTailSubscriber(conn)
+		return
+	}
+
+	// Quitting never returns, so the connection has to be closed before the
+	// command is carried out. The client isn't waiting for a response anyway
+	if !expectsResponse(cmd.id) {
+		conn.Close()
+		handlePipeCommand(cmd)
 		return
 	}
 
-	fmt.Println("Logging output to", logFile.Name())
+	response := handlePipeCommand(cmd)
 
-	// Track the last known proxy enabled and proxy server states
-	var lastProxyEnable uint64 = 0
-	var lastProxyServer string = ""
+	// Send the response back to the process to let it know if the command
+	// was successful or not
+	err = writeFrame(conn, response)
+	conn.Close()
 
-	// A nested function that checks if any of the settings have changed.
-	// Returns true if the program should continue checking for updates, false
-	// for if the program should end.
-	// Returns false in the case of errors
-	var checkForChanges = func() bool {
-		if !listenerEnabled {
-			return true
-		}
+	if err != nil {
+		printError("Failed to write pipe response:", err)
+	}
+}
 
-		// Read the ProxyEnable setting
-		proxyEnable, _, err := key.GetIntegerValue("ProxyEnable")
+// Executes a command received over the pipe and returns the response to send
+// back. Usually that's a response code depending on if the command was
+// successful, but some commands respond with data instead
+func handlePipeCommand(cmd command) []byte {
+	switch cmd.id {
+	case CMD_HISTORY:
+		history, err := encodeHistory()
 		if err != nil {
-			fmt.Println("Failed to read ProxyEnable:", err)
-			return false
+			printError("Failed to encode history:", err)
+			return []byte{RESPONSE_INTERNAL_ERROR}
 		}
 
-		// Read the IP address of the proxy
-		proxyServer, _, err := key.GetStringValue("ProxyServer")
+		return history
+
+	case CMD_STATUS:
+		status, err := json.Marshal(controller.Status())
 		if err != nil {
-			// ProxyEnable will always exist in the registry, but there's a
-			// chance that the ProxyServer value isn't set yet
-			if err == registry.ErrNotExist {
-				lastProxyServer = ""
-			} else {
-				fmt.Println("Failed to read ProxyServer:", err)
-				return false
-			}
+			printError("Failed to encode status:", err)
+			return []byte{RESPONSE_INTERNAL_ERROR}
 		}
 
-		// If neither value has changed, then there's nothing to log, stop here
-		if proxyEnable == lastProxyEnable && proxyServer == lastProxyServer {
-			return true
+		return status
+
+	case CMD_CONFIG:
+		// Same as with writing the proxy settings, anyone could be asking
+		// for the service's secrets
+		liveConfig := currentConfig()
+		if cmd.redact {
+			liveConfig = redactConfig(liveConfig)
+		} else if runningAsService {
+			printWarning("Refusing to share the unredacted config with a pipe client, running as a service")
+			return []byte{RESPONSE_ACCESS_DENIED}
+		}
+
+		config, err := json.Marshal(liveConfig)
+		if err != nil {
+			printError("Failed to encode config:", err)
+			return []byte{RESPONSE_INTERNAL_ERROR}
 		}
 
-		lastProxyEnable = proxyEnable
-		lastProxyServer = proxyServer
+		return config
 
-		// Get the time, for the log messages
-		now := time.Now()
-		formattedTime := now.Format(time.ANSIC)
+	case CMD_PAUSE:
+		return []byte{controller.Pause(cmd.duration)}
 
-		// Off messages shouldn't have any information after the 'off' part
-		if proxyEnable == 0 {
-			fmt.Fprintf(logFile, "%s\tproxy off\n", formattedTime)
-			return true
+	case CMD_ENABLE_PROXY, CMD_DISABLE_PROXY:
+		// Every signed in user can connect to a service's pipe, and the
+		// service writes as SYSTEM, which would let anyone change the
+		// machine-wide settings
+		if runningAsService {
+			printWarning("Refusing to change the proxy settings for a pipe client, running as a service")
+			return []byte{RESPONSE_ACCESS_DENIED}
 		}
 
-		fmt.Fprintf(logFile, "%s\tproxy on, %s\n", formattedTime, proxyServer)
-		return true
-	}
+		return []byte{setProxy(currentConfig(), cmd.id == CMD_ENABLE_PROXY, cmd.server)}
 
-	// Check for changes every second, unless the check function returns false
-	for {
-		if !checkForChanges() {
-			return
+	case CMD_CLEARLOG:
+		// The same goes for the service's log, which would let anyone wipe
+		// the record of the changes
+		if runningAsService {
+			printWarning("Refusing to clear the log for a pipe client, running as a service")
+			return []byte{RESPONSE_ACCESS_DENIED}
 		}
 
-		time.Sleep(1 * time.Second)
-	}
-}
+		return []byte{controller.ClearLog()}
 
-// Enable the monitor
-func startListening() {
-	listenerEnabled = true
-	fmt.Println("Now listening to proxy changes")
+	default:
+		return []byte{executeCommand(cmd.id)}
+	}
 }
 
-// Disable the monitor
-func stopListening() {
-	listenerEnabled = false
-	fmt.Println("No longer listening to proxy changes")
+// Executes a command sent from another instance of this program, the tray or
+// the HTTP server and returns one of the response codes
+func executeCommand(cmd byte) byte {
+	switch cmd {
+	case CMD_START:
+		return controller.Start()
+	case CMD_STOP:
+		return controller.Stop()
+	case CMD_RESTART:
+		return controller.Restart()
+	case CMD_RELOAD:
+		return controller.Reload()
+	case CMD_CLEARLOG:
+		return controller.ClearLog()
+	case CMD_QUIT:
+		controller.Quit()
+		return RESPONSE_OK
+	default:
+		printError("Received an unknown command:", cmd)
+		return RESPONSE_INTERNAL_ERROR
+	}
 }
 
-// Listens to messages from other instances of this program
-func listenToNamedPipe() {
-	// Listen to pipe messages
-	l, err := winio.ListenPipe(PIPE_FILE, nil)
+func main() {
+	// When started by the Service Control Manager, there's no console or tray,
+	// and the SCM makes sure there's only one instance of the service
+	isService, err := isWindowsService()
 	if err != nil {
-		fmt.Println("Failed to listen to pipe!", err)
+		printError("Failed to determine if running as a service:", err)
+	}
+
+	if isService {
+		serviceMain()
 		return
 	}
 
-	defer l.Close()
+	// Version and service management commands are carried out by this
+	// process itself, without touching the lock file or the pipe
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "-version":
+			fmt.Println(versionString())
+			return
 
-	// We only read 1 byte (the command number) and send 1 byte (a 0 or 1),
-	// so allocate a 1 byte long buffer
-	buffer := make([]byte, 1)
+		case "-install-service":
+			err = installService()
+			if err != nil {
+				printError("Failed to install service:", err)
+				return
+			}
 
-	for {
-		conn, err := l.Accept()
+			fmt.Println("Installed the", SERVICE_NAME, "service.")
+			return
 
-		if err != nil {
-			fmt.Println("Failed to read pipe input", err)
-			continue
-		}
+		case "-uninstall-service":
+			err = uninstallService()
+			if err != nil {
+				printError("Failed to uninstall service:", err)
+				return
+			}
 
-		_, err = conn.Read(buffer)
-		if err != nil {
-			fmt.Println("Failed to read", err)
-			conn.Close()
-			continue
+			fmt.Println("Uninstalled the", SERVICE_NAME, "service.")
+			return
 		}
+	}
 
-		// Get the command that was read and execute it
-		cmd := buffer[0]
-		execRes := executeCommand(cmd)
+	// The command line is parsed before connecting to anything, so that a
+	// typo doesn't open a pipe connection for nothing. The instance also
+	// decides which lock file and pipe to use
+	cmd, err := parseCommandLine()
+	if err != nil {
+		printError("Failed to parse command line arguments:", err)
+		fmt.Println()
+		fmt.Println(USAGE)
+		os.Exit(EXIT_USAGE)
+	}
 
-		if execRes {
-			buffer[0] = 1
-		} else {
-			buffer[0] = 0
-		}
+	quietOption = cmd.quiet
+	if cmd.stdin {
+		consoleOutput = os.Stderr
+	}
+	setupColorOutput(cmd.noColor)
 
-		// Send the 0 or 1 back to the process to let it know if the
-		// command was successful or not
-		_, err = conn.Write(buffer)
-		conn.Close()
+	// Reading the registry is harmless, so there's no need to check for
+	// another instance or go through it
+	if cmd.id == CMD_ONCE {
+		os.Exit(printCurrentProxy(cmd.json))
+	}
 
-		if err == nil {
-			continue
-		}
+	useInstance(cmd.instance)
 
-		fmt.Println("Failed to write pipe response:", err)
+	// Only looks at the running monitor, without ever becoming one
+	if cmd.id == CMD_PING {
+		os.Exit(pingMonitor())
 	}
-}
 
-// Executes a command sent from another instance of this program
-func executeCommand(cmd byte) bool {
-	switch cmd {
-	case CMD_START:
-		if listenerEnabled {
-			return false
-		}
-		startListening()
+	// Read-only as well, the running monitor is only asked for its config
+	if cmd.id == CMD_CONFIG {
+		os.Exit(printEffectiveConfig(cmd.redact))
+	}
 
-	case CMD_STOP:
-		if !listenerEnabled {
-			return false
-		}
-		stopListening()
+	if cmd.id == CMD_EXPORT {
+		os.Exit(exportHistory(cmd.exportPath))
+	}
 
-	case CMD_QUIT:
-		fmt.Println("Exiting...")
-		os.Exit(0)
+	if cmd.id == CMD_DOCTOR {
+		os.Exit(runDoctor())
 	}
 
-	return true
-}
+	// Goes through the running monitor, so it knows the change was its own.
+	// Without one there's nobody to tell, and the registry is written here
+	if cmd.id == CMD_ENABLE_PROXY || cmd.id == CMD_DISABLE_PROXY {
+		if !clientMain(cmd) {
+			os.Exit(setProxyLocally(cmd))
+		}
+		return
+	}
 
-func main() {
 	// Get the lock file
-	_, err := singleinstance.CreateLockFile(LOCK_FILE)
+	lockFile, err := singleinstance.CreateLockFile(lockFileName)
 
 	// Error will not be nil when another process is using the lock file.
 	// That means there's already an instance of this program running.
 	if err != nil {
-		clientMain()
-		return
+		if clientMain(cmd) {
+			return
+		}
+
+		// The lock file exists, but nothing is listening on the pipe, so the
+		// previous main instance probably crashed. Take over its place
+		lockFile, err = takeOverStaleLock()
+		if err != nil {
+			printError("Monitor is not running, but failed to take over its lock file:", err)
+			return
+		}
+
+		printInfo("Previous monitor instance is gone, starting a new one")
 	}
 
 	// Lock file doesn't exist or references a process that no longer exists,
 	// this process is now the main instance of this program
-	serverMain()
+	onShutdown(func() {
+		lockFile.Close()
+		os.Remove(lockFileName)
+	})
+
+	handleInterrupts()
+	handleHangups()
+
+	serverMain(cmd)
+
+	// The monitor only stops on its own when something failed, still clean up
+	// before exiting
+	shutdown()
 }
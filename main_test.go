@@ -0,0 +1,149 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want command
+	}{
+		{name: "no arguments", args: nil, want: command{id: NO_COMMAND}},
+		{name: "start", args: []string{"-start"}, want: command{id: CMD_START}},
+		{name: "stop", args: []string{"-stop"}, want: command{id: CMD_STOP}},
+		{name: "quit", args: []string{"-quit"}, want: command{id: CMD_QUIT}},
+		{name: "restart", args: []string{"-restart"}, want: command{id: CMD_RESTART}},
+		{name: "reload", args: []string{"-reload"}, want: command{id: CMD_RELOAD}},
+		{name: "history", args: []string{"-history"}, want: command{id: CMD_HISTORY}},
+		{name: "status", args: []string{"-status"}, want: command{id: CMD_STATUS}},
+		{name: "tail", args: []string{"-tail"}, want: command{id: CMD_TAIL}},
+		{name: "once", args: []string{"-once"}, want: command{id: CMD_ONCE}},
+		{name: "ping", args: []string{"-ping"}, want: command{id: CMD_PING}},
+		{name: "config", args: []string{"-config"}, want: command{id: CMD_CONFIG}},
+		{name: "doctor", args: []string{"-doctor"}, want: command{id: CMD_DOCTOR}},
+		{name: "clearlog", args: []string{"-clearlog"}, want: command{id: CMD_CLEARLOG}},
+		{name: "disable proxy", args: []string{"-disable-proxy"}, want: command{id: CMD_DISABLE_PROXY}},
+		{
+			name: "pause",
+			args: []string{"-pause", "30s"},
+			want: command{id: CMD_PAUSE, duration: 30 * time.Second},
+		},
+		{
+			name: "export",
+			args: []string{"-export", "history.csv"},
+			want: command{id: CMD_EXPORT, exportPath: "history.csv"},
+		},
+		{
+			name: "enable proxy",
+			args: []string{"-enable-proxy", "-server", "proxy.corp:8080"},
+			want: command{id: CMD_ENABLE_PROXY, server: "proxy.corp:8080"},
+		},
+		{
+			name: "server before enable proxy",
+			args: []string{"-server", "http=a:80;https=b:443", "-enable-proxy"},
+			want: command{id: CMD_ENABLE_PROXY, server: "http=a:80;https=b:443"},
+		},
+		{name: "verbose", args: []string{"-verbose"}, want: command{id: NO_COMMAND, verbose: true}},
+		{name: "json", args: []string{"-once", "-json"}, want: command{id: CMD_ONCE, json: true}},
+		{name: "watch file", args: []string{"-watch-file"}, want: command{id: NO_COMMAND, watchFile: true}},
+		{name: "redact", args: []string{"-config", "-redact"}, want: command{id: CMD_CONFIG, redact: true}},
+		{name: "no tray", args: []string{"-no-tray"}, want: command{id: NO_COMMAND, noTray: true}},
+		{name: "stdin", args: []string{"-stdin"}, want: command{id: NO_COMMAND, stdin: true}},
+		{name: "simulate", args: []string{"-simulate"}, want: command{id: NO_COMMAND, simulate: true}},
+		{name: "quiet", args: []string{"-quiet"}, want: command{id: NO_COMMAND, quiet: true}},
+		{name: "no color", args: []string{"-no-color"}, want: command{id: NO_COMMAND, noColor: true}},
+		{
+			name: "instance",
+			args: []string{"-instance", "work_2", "-status"},
+			want: command{id: CMD_STATUS, instance: "work_2"},
+		},
+		{
+			name: "options around the command",
+			args: []string{"-verbose", "-no-tray", "-start", "-quiet", "-no-color", "-watch-file"},
+			want: command{id: CMD_START, verbose: true, noTray: true, quiet: true, noColor: true, watchFile: true},
+		},
+		{
+			name: "every start option",
+			args: []string{"-start", "-verbose", "-watch-file", "-no-tray", "-stdin", "-simulate", "-quiet", "-no-color", "-instance", "test"},
+			want: command{
+				id:        CMD_START,
+				verbose:   true,
+				watchFile: true,
+				noTray:    true,
+				stdin:     true,
+				simulate:  true,
+				quiet:     true,
+				noColor:   true,
+				instance:  "test",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseCommand(test.args)
+			if err != nil {
+				t.Fatalf("parseCommand(%q) failed: %v", test.args, err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseCommand(%q) = %+v, want %+v", test.args, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+
+		// Part of the error message
+		want string
+	}{
+		{name: "unknown command", args: []string{"-frobnicate"}, want: "unknown command: -frobnicate"},
+		{name: "unknown after a command", args: []string{"-start", "-nope"}, want: "unknown command: -nope"},
+		{name: "unknown before a command", args: []string{"-nope", "-start"}, want: "unknown command: -nope"},
+		{name: "unknown after an option", args: []string{"-verbose", "-nope"}, want: "unknown command: -nope"},
+		{name: "two commands with arguments", args: []string{"-pause", "30s", "-export", "history.csv"}, want: "only one command can be given: -export"},
+		{name: "argument without a dash", args: []string{"start"}, want: "unknown command: start"},
+		{name: "two commands", args: []string{"-start", "-stop"}, want: "only one command can be given: -stop"},
+		{name: "same command twice", args: []string{"-status", "-status"}, want: "only one command can be given"},
+		{name: "instance without a name", args: []string{"-instance"}, want: "-instance requires a name"},
+		{name: "invalid instance name", args: []string{"-instance", "../evil"}, want: "invalid instance name: ../evil"},
+		{name: "empty instance name", args: []string{"-instance", ""}, want: "invalid instance name"},
+		{name: "server without a value", args: []string{"-enable-proxy", "-server"}, want: "-server requires a proxy server"},
+		{name: "server with spaces", args: []string{"-enable-proxy", "-server", "a b:80"}, want: "proxy server can't contain spaces"},
+		{name: "empty server", args: []string{"-enable-proxy", "-server", " "}, want: "empty proxy server"},
+		{name: "enable proxy without server", args: []string{"-enable-proxy"}, want: "-enable-proxy requires -server"},
+		{name: "server without enable proxy", args: []string{"-disable-proxy", "-server", "a:80"}, want: "-server can only be given with -enable-proxy"},
+		{name: "server on its own", args: []string{"-server", "a:80"}, want: "-server can only be given with -enable-proxy"},
+		{name: "pause without a duration", args: []string{"-pause"}, want: "-pause requires a duration"},
+		{name: "pause with a bad duration", args: []string{"-pause", "soon"}, want: "invalid pause duration: soon"},
+		{name: "pause with a negative duration", args: []string{"-pause", "-5s"}, want: "invalid pause duration: -5s"},
+		{name: "pause with no duration", args: []string{"-pause", "0s"}, want: "invalid pause duration: 0s"},
+		{name: "export without a file", args: []string{"-export"}, want: "-export requires a file name"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseCommand(test.args)
+			if err == nil {
+				t.Fatalf("parseCommand(%q) = %+v, want an error", test.args, got)
+			}
+
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("parseCommand(%q) error = %q, want it to contain %q", test.args, err, test.want)
+			}
+
+			if got.id != NO_COMMAND {
+				t.Errorf("parseCommand(%q) returned command %d along with the error", test.args, got.id)
+			}
+		})
+	}
+}
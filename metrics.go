@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Content type of the Prometheus text exposition format
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Writes a single metric without labels, along with its help and type lines
+func writeMetric(w io.Writer, name string, metricType string, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
+
+// Escapes a label value, backslashes, quotes and newlines need escaping
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Converts a bool to the 0 or 1 of a gauge
+func gaugeValue(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// Writes the monitor's status in the Prometheus text exposition format
+func writeMetrics(w io.Writer, status monitorStatus) {
+	writeMetric(w, "proxy_monitor_changes_total", "counter", "Proxy changes seen since the monitor started.", status.Stats.Changes)
+	writeMetric(w, "proxy_monitor_enabled_total", "counter", "Times the proxy was turned on.", status.Stats.Enabled)
+	writeMetric(w, "proxy_monitor_disabled_total", "counter", "Times the proxy was turned off.", status.Stats.Disabled)
+	writeMetric(w, "proxy_monitor_server_changes_total", "counter", "Times the proxy server changed while the proxy stayed on.", status.Stats.ServerChanges)
+	writeMetric(w, "proxy_monitor_uptime_seconds", "gauge", "How long the monitor has been running.", status.UptimeSeconds)
+	writeMetric(w, "proxy_monitor_monitoring", "gauge", "Whether the monitor is checking for changes.", gaugeValue(status.Monitoring))
+
+	fmt.Fprintln(w, "# HELP proxy_monitor_proxy_enabled Whether the proxy is turned on, per registry hive.")
+	fmt.Fprintln(w, "# TYPE proxy_monitor_proxy_enabled gauge")
+	for _, hive := range status.Hives {
+		fmt.Fprintf(w, "proxy_monitor_proxy_enabled{hive=\"%s\"} %d\n", escapeLabelValue(hive.Hive), gaugeValue(hive.ProxyEnabled))
+	}
+}
+
+// Serves the metrics for Prometheus to scrape
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	writeMetrics(w, controller.Status())
+}
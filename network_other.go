@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// Adapter names are only looked up on Windows, the log lines just leave the
+// connection out elsewhere
+func activeConnectionName() (string, error) {
+	return "", errors.New("connection names are only available on Windows")
+}
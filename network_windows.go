@@ -0,0 +1,72 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Makes GetAdaptersAddresses include each adapter's gateways, which is how
+// the adapter with the active connection is told apart from the others
+const GAA_FLAG_INCLUDE_GATEWAYS = 0x0080
+
+// Returns the name of the network adapter the current connection goes
+// through, like "Wi-Fi" or "Ethernet". When several adapters are connected,
+// the one Windows prefers for routing (the lowest metric) is picked
+func activeConnectionName() (string, error) {
+	adapters, err := getAdapterAddresses()
+	if err != nil {
+		return "", err
+	}
+
+	var best *windows.IpAdapterAddresses
+
+	for adapter := adapters; adapter != nil; adapter = adapter.Next {
+		if adapter.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+		if adapter.IfType == windows.IF_TYPE_SOFTWARE_LOOPBACK {
+			continue
+		}
+
+		// Adapters without a gateway can't be the one the internet
+		// connection goes through
+		if adapter.FirstGatewayAddress == nil {
+			continue
+		}
+
+		if best == nil || adapter.Ipv4Metric < best.Ipv4Metric {
+			best = adapter
+		}
+	}
+
+	if best == nil {
+		return "", errors.New("no active network connection")
+	}
+
+	return windows.UTF16PtrToString(best.FriendlyName), nil
+}
+
+// Gets the linked list of every network adapter on the machine
+func getAdapterAddresses() (*windows.IpAdapterAddresses, error) {
+	// Start with a 15 KB buffer, which is what Microsoft recommends, and grow
+	// it if the adapter list doesn't fit
+	size := uint32(15 * 1024)
+
+	for {
+		buffer := make([]byte, size)
+		adapters := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buffer[0]))
+
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, GAA_FLAG_INCLUDE_GATEWAYS, 0, adapters, &size)
+		if err == nil {
+			return adapters, nil
+		}
+
+		if err != windows.ERROR_BUFFER_OVERFLOW {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Title shown on every desktop notification
+const NOTIFICATION_TITLE = "Proxy Monitor"
+
+// Shows a notification about the proxy being turned on or off, or its server
+// being swapped
+func notifyProxyChange(prefix string, kind string, proxyServer string, interval time.Duration) {
+	servers := displayProxyServer(proxyServer)
+
+	switch kind {
+	case EVENT_DISABLE:
+		sendLimitedNotification(prefix+"Proxy disabled", interval)
+	case EVENT_SERVER_CHANGE:
+		sendLimitedNotification(prefix+"Proxy server changed: "+servers, interval)
+	default:
+		sendLimitedNotification(prefix+"Proxy enabled: "+servers, interval)
+	}
+}
+
+// Keeps a proxy that's toggled over and over from flooding the desktop with
+// notifications. Guarded by notifyMutex
+var notifyMutex sync.Mutex
+var lastNotificationAt time.Time
+
+// Notifications held back since the last one was shown, and the timer that
+// shows the summary of them
+var suppressedNotifications int
+var suppressedTimer *time.Timer
+
+// Shows a notification, unless one was already shown less than the interval
+// ago. Held back notifications are counted and summed up in a single one once
+// the interval has passed. 0 shows every notification
+func sendLimitedNotification(message string, interval time.Duration) {
+	notifyMutex.Lock()
+	defer notifyMutex.Unlock()
+
+	now := time.Now()
+	if interval <= 0 || (suppressedTimer == nil && now.Sub(lastNotificationAt) >= interval) {
+		lastNotificationAt = now
+		sendNotification(message)
+		return
+	}
+
+	suppressedNotifications++
+	if suppressedTimer == nil {
+		suppressedTimer = time.AfterFunc(lastNotificationAt.Add(interval).Sub(now), sendSuppressedNotification)
+	}
+}
+
+// Shows how many notifications were held back, which counts as a
+// notification itself, so changes that keep coming are summed up again
+func sendSuppressedNotification() {
+	notifyMutex.Lock()
+	defer notifyMutex.Unlock()
+
+	count := suppressedNotifications
+	suppressedNotifications = 0
+	suppressedTimer = nil
+	lastNotificationAt = time.Now()
+
+	if count == 1 {
+		sendNotification("1 proxy change suppressed")
+		return
+	}
+
+	sendNotification(fmt.Sprintf("%d proxy changes suppressed", count))
+}
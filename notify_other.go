@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// There are no toast notifications outside of Windows, so the message is just
+// printed, which still shows when a notification would have been sent
+func sendNotification(message string) {
+	printInfo("Notification:", message)
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Environment variable the notification text is passed to PowerShell in.
+// Passing it through the environment, instead of the script itself, means
+// proxy server strings never have to be escaped
+const NOTIFICATION_ENV = "PROXY_MONITOR_NOTIFICATION"
+
+// Windows doesn't show toasts from apps without a registered app ID, so the
+// notification is shown as coming from PowerShell itself
+const NOTIFICATION_APP_ID = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+
+// Process creation flag that stops a console window flashing up while the
+// notification is sent
+const CREATE_NO_WINDOW = 0x08000000
+
+// PowerShell script that shows a toast using the WinRT notification API
+const NOTIFICATION_SCRIPT = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode('` + NOTIFICATION_TITLE + `')) > $null
+$text.Item(1).AppendChild($template.CreateTextNode($env:` + NOTIFICATION_ENV + `)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('` + NOTIFICATION_APP_ID + `').Show($toast)
+`
+
+// Shows a Windows toast notification with the given message. The notification
+// is sent in the background, so this never blocks the monitor
+func sendNotification(message string) {
+	go func() {
+		cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", NOTIFICATION_SCRIPT)
+		cmd.Env = append(os.Environ(), NOTIFICATION_ENV+"="+message)
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			HideWindow:    true,
+			CreationFlags: CREATE_NO_WINDOW,
+		}
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			printError("Failed to show notification:", err, string(output))
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Proxy settings of a single hive, as printed by the -once command
+type currentProxy struct {
+	Hive          string `json:"hive"`
+	ProxyEnabled  bool   `json:"proxy_enabled"`
+	ProxyServer   string `json:"proxy_server"`
+	ProxyOverride string `json:"proxy_override"`
+
+	// ProxyServer exactly as it was in the registry, before normalizing it
+	Raw string `json:"raw"`
+}
+
+// Reads the proxy settings of a hive straight from the registry
+func readCurrentProxy(hive proxymon.Hive) (currentProxy, error) {
+	state, err := proxymon.CurrentState(hive)
+	if err != nil {
+		return currentProxy{Hive: string(hive)}, err
+	}
+
+	return currentProxy{
+		Hive:          string(state.Hive),
+		ProxyEnabled:  state.Enabled,
+		ProxyServer:   normalizeProxyServer(state.Server),
+		ProxyOverride: state.Override,
+		Raw:           state.Server,
+	}, nil
+}
+
+// Prints the current proxy settings of every hive in the config and returns
+// the exit code. Only reads the registry, so it works whether or not the
+// monitor is running
+func printCurrentProxy(asJSON bool) int {
+	config := loadConfig()
+
+	// The config has already been validated, so this can't fail
+	hives, _ := parseRegistryHives(config.RegistryHive)
+
+	results := []currentProxy{}
+	for _, hive := range hives {
+		current, err := readCurrentProxy(hive)
+
+		// Profiles that never set up a proxy, like service accounts, don't
+		// have the key at all
+		_, isUser := hive.UserSID()
+		if isUser && errors.Is(err, proxymon.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read %s proxy settings: %v\n", string(hive), err)
+			return 1
+		}
+
+		results = append(results, current)
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to encode proxy settings:", err)
+			return 1
+		}
+
+		fmt.Println(string(data))
+		return 0
+	}
+
+	for _, current := range results {
+		if !current.ProxyEnabled {
+			fmt.Printf("%s: proxy off\n", current.Hive)
+			continue
+		}
+
+		fmt.Printf("%s: proxy on, %s\n", current.Hive, displayProxyServer(current.Raw))
+
+		if current.ProxyOverride != "" {
+			fmt.Printf("%s: bypass %s\n", current.Hive, current.ProxyOverride)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// How long after the monitor changed the proxy settings itself a matching
+// change is still put down to it
+const SELF_CHANGE_WINDOW = 10 * time.Second
+
+// Proxy settings the monitor is about to write to a hive, and when
+type selfChange struct {
+	proxyEnable uint64
+	proxyServer string
+	at          time.Time
+}
+
+// Changes the monitor made itself that haven't been seen by a watcher yet,
+// keyed by the hive
+var selfChanges = map[string]selfChange{}
+var selfChangesMutex sync.Mutex
+
+// Remembers that the monitor is about to change the hive's proxy settings
+// itself, so the watcher doesn't mistake the change for an external one.
+// Must be called before the registry is written
+func expectSelfChange(hive string, proxyEnable uint64, proxyServer string) {
+	selfChangesMutex.Lock()
+	defer selfChangesMutex.Unlock()
+
+	selfChanges[hive] = selfChange{proxyEnable: proxyEnable, proxyServer: proxyServer, at: time.Now()}
+}
+
+// Reports whether a change the watcher just saw was made by the monitor
+// itself, and forgets about it if it was
+func takeSelfChange(hive string, proxyEnable uint64, proxyServer string) bool {
+	selfChangesMutex.Lock()
+	defer selfChangesMutex.Unlock()
+
+	change, ok := selfChanges[hive]
+	if !ok {
+		return false
+	}
+
+	if time.Since(change.at) > SELF_CHANGE_WINDOW {
+		delete(selfChanges, hive)
+		return false
+	}
+
+	if change.proxyEnable != proxyEnable || change.proxyServer != proxyServer {
+		return false
+	}
+
+	delete(selfChanges, hive)
+	return true
+}
+
+// Describes where a change came from, for the end of a log line
+func changeOrigin(self bool) string {
+	if self {
+		return " (self)"
+	}
+
+	return " (external)"
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Escape codes used to color the console output
+const ANSI_RESET = "\x1b[0m"
+const ANSI_RED = "\x1b[31m"
+const ANSI_GREEN = "\x1b[32m"
+const ANSI_YELLOW = "\x1b[33m"
+const ANSI_CYAN = "\x1b[36m"
+
+// Environment variable that turns colors off when set to anything, see
+// https://no-color.org
+const NO_COLOR_ENV = "NO_COLOR"
+
+// Set by the -quiet option, silences the informational output of the main
+// instance. Errors and warnings are still printed
+var quietOption bool
+
+// Where the console lines go. Stderr with -stdin, so stdout only has the
+// JSON responses the parent process reads
+var consoleOutput = os.Stdout
+
+// Whether the console output is colored. Only turned on when stdout is a
+// console that understands the escape codes
+var colorOutput bool
+
+// Decides whether the console output is colored, unless -no-color was given
+func setupColorOutput(noColor bool) {
+	colorOutput = !noColor && os.Getenv(NO_COLOR_ENV) == "" && consoleSupportsColor()
+}
+
+// Levels every console line starts with, padded so the messages line up
+const LEVEL_INFO = "INFO "
+const LEVEL_WARN = "WARN "
+const LEVEL_ERROR = "ERROR"
+const LEVEL_EVENT = "EVENT"
+
+// Prints a line in the given color, or as is if the output isn't colored
+func printColored(color string, line string) {
+	writeColored(consoleOutput, color, line)
+}
+
+// Writes a line in the given color to the output
+func writeColored(output *os.File, color string, line string) {
+	if colorOutput && color != "" {
+		fmt.Fprintln(output, color+line+ANSI_RESET)
+		return
+	}
+
+	fmt.Fprintln(output, line)
+}
+
+// Prints a line with its level in front. Errors go to stderr, so they still
+// show up when the rest of the output is redirected
+func printLevel(level string, color string, line string) {
+	output := consoleOutput
+	if level == LEVEL_ERROR {
+		output = os.Stderr
+	}
+
+	writeColored(output, color, level+" "+line)
+}
+
+// Formats the operands the same way fmt.Println does, without the newline
+func sprintLine(a ...any) string {
+	return strings.TrimSuffix(fmt.Sprintln(a...), "\n")
+}
+
+// Prints an informational line, unless -quiet was given
+func printInfo(a ...any) {
+	if !quietOption {
+		printLevel(LEVEL_INFO, "", sprintLine(a...))
+	}
+}
+
+// Prints a formatted informational line, unless -quiet was given
+func printInfof(format string, a ...any) {
+	if !quietOption {
+		printLevel(LEVEL_INFO, "", strings.TrimSuffix(fmt.Sprintf(format, a...), "\n"))
+	}
+}
+
+// Prints a warning, something went wrong but the monitor works around it
+func printWarning(a ...any) {
+	printLevel(LEVEL_WARN, ANSI_YELLOW, sprintLine(a...))
+}
+
+// Prints a formatted warning
+func printWarningf(format string, a ...any) {
+	printLevel(LEVEL_WARN, ANSI_YELLOW, strings.TrimSuffix(fmt.Sprintf(format, a...), "\n"))
+}
+
+// Prints an error
+func printError(a ...any) {
+	printLevel(LEVEL_ERROR, ANSI_RED, sprintLine(a...))
+}
+
+// Prints a formatted error
+func printErrorf(format string, a ...any) {
+	printLevel(LEVEL_ERROR, ANSI_RED, strings.TrimSuffix(fmt.Sprintf(format, a...), "\n"))
+}
+
+// Prints a proxy change, green when the proxy was turned on, red when it was
+// turned off and cyan when only the server changed. Silenced by -quiet, same
+// as the informational output
+func printEvent(kind string, line string) {
+	if quietOption {
+		return
+	}
+
+	color := ANSI_CYAN
+	switch kind {
+	case EVENT_ENABLE:
+		color = ANSI_GREEN
+	case EVENT_DISABLE:
+		color = ANSI_RED
+	}
+
+	printLevel(LEVEL_EVENT, color, line)
+}
+
+// Remembers which errors have been printed, so something that fails on every
+// poll is only reported once, until it works again
+type failureReporter struct {
+	mutex  sync.Mutex
+	failed map[string]string
+}
+
+// Reports whether the error should be printed for the key, which is the case
+// unless the same error was the last one reported for it
+func (r *failureReporter) shouldReport(key string, err error) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.failed == nil {
+		r.failed = map[string]string{}
+	}
+
+	previous, ok := r.failed[key]
+	r.failed[key] = err.Error()
+	return !ok || previous != err.Error()
+}
+
+// Forgets the error for the key once reading works again, so the next
+// failure is reported
+func (r *failureReporter) clear(key string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.failed, key)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Value in the Internet Settings holding the hosts that bypass the proxy,
+// like "localhost;*.corp.example.com;<local>"
+const PROXY_OVERRIDE_VALUE = "ProxyOverride"
+
+// Reads the hive's proxy bypass list, empty if there isn't one
+func readProxyOverride(hive proxymon.Hive) (string, error) {
+	key, err := proxymon.OpenKey(hive, proxymon.INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+
+	override, _, err := key.GetStringValue(PROXY_OVERRIDE_VALUE)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return "", nil
+	}
+
+	return override, err
+}
+
+// Shows a bypass list in a log line, which could be anything another program
+// wrote to the registry
+func displayProxyOverride(override string) string {
+	if override == "" {
+		return "(none)"
+	}
+
+	return sanitizeLogValue(override, MAX_DISPLAYED_SERVER_LENGTH)
+}
+
+// Checks the hive's proxy bypass list in a loop and logs when it changes,
+// since a host added to it silently skips the proxy. The list when the
+// monitor starts is only the baseline, so it isn't logged
+func watchProxyOverride(ctx context.Context, hive proxymon.Hive, prefix string, logFile *monitorLog) {
+	last := ""
+	hasBaseline := false
+	lastError := ""
+
+	for {
+		config := currentConfig()
+
+		if controller.Enabled() {
+			override, err := readProxyOverride(hive)
+
+			if err != nil {
+				// Only reported once, until the value can be read again
+				if err.Error() != lastError {
+					printError(prefix+"Failed to read ProxyOverride:", err)
+					lastError = err.Error()
+				}
+			} else {
+				if hasBaseline && override != last && config.logsEvent(EVENT_OVERRIDE_CHANGE) {
+					message := prefix + "proxy bypass list changed: " + displayProxyOverride(last) + " -> " + displayProxyOverride(override)
+
+					writeLogEntry(logFile, message)
+					writeSeverityEvent(config, SEVERITY_INFO, message)
+					printEvent(EVENT_OVERRIDE_CHANGE, time.Now().Format(config.timestampLayout())+" "+message)
+				}
+
+				last = override
+				hasBaseline = true
+				lastError = ""
+			}
+		}
+
+		if !sleepContext(ctx, config.pollInterval()) {
+			return
+		}
+	}
+}
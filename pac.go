@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Value in the Internet Settings holding the URL of the PAC script
+const AUTO_CONFIG_URL_VALUE = "AutoConfigURL"
+
+// What's known about the PAC file's contents. The hash is only worked out
+// again when the modification time or the size changes
+type pacFileState struct {
+	path    string
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// Reads the hive's PAC script URL, empty if there isn't one
+func readAutoConfigURL(hive proxymon.Hive) (string, error) {
+	key, err := proxymon.OpenKey(hive, proxymon.INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+
+	autoConfigURL, _, err := key.GetStringValue(AUTO_CONFIG_URL_VALUE)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return "", nil
+	}
+
+	return autoConfigURL, err
+}
+
+// Turns a file:// PAC URL into a local path. file:///C:/proxy.pac becomes
+// C:\proxy.pac and file://server/share/proxy.pac a UNC path. Returns false
+// for http(s) URLs and anything else that can't be watched locally
+func resolvePacFile(autoConfigURL string) (string, bool) {
+	parsed, err := url.Parse(autoConfigURL)
+	if err != nil || !strings.EqualFold(parsed.Scheme, "file") {
+		return "", false
+	}
+
+	path := parsed.Path
+	if path == "" {
+		// file:C:/proxy.pac, without the slashes
+		path = parsed.Opaque
+	}
+	if path == "" {
+		return "", false
+	}
+
+	// Drop the slash in front of a drive letter, /C:/proxy.pac
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+
+	if parsed.Host != "" && !strings.EqualFold(parsed.Host, "localhost") {
+		path = "//" + parsed.Host + path
+	}
+
+	return filepath.FromSlash(path), true
+}
+
+// Reads the PAC file's modification time and size, and hashes its contents
+// if either differs from the previous state
+func readPacFile(path string, previous *pacFileState) (pacFileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return pacFileState{}, err
+	}
+
+	state := pacFileState{path: path, modTime: info.ModTime(), size: info.Size()}
+	if previous != nil && previous.path == path && previous.modTime.Equal(state.modTime) && previous.size == state.size {
+		state.hash = previous.hash
+		return state, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pacFileState{}, err
+	}
+
+	state.hash = sha256.Sum256(data)
+	return state, nil
+}
+
+// With watch_pac_file on, checks the contents of the hive's PAC file in a
+// loop and logs when they change. The registry doesn't change when the file
+// is edited, so it wouldn't be noticed otherwise. Only file:// URLs are
+// watched, and switching to another URL just starts over with a new baseline
+func watchPacFile(ctx context.Context, hive proxymon.Hive, prefix string, logFile *monitorLog) {
+	var last *pacFileState
+	lastURL := ""
+	lastError := ""
+
+	for {
+		config := currentConfig()
+
+		if !config.WatchPacFile || !controller.Enabled() {
+			last = nil
+			lastURL = ""
+			if !sleepContext(ctx, config.pollInterval()) {
+				return
+			}
+			continue
+		}
+
+		autoConfigURL, err := readAutoConfigURL(hive)
+		if err != nil {
+			printError(prefix+"Failed to read PAC file URL:", err)
+			if !sleepContext(ctx, config.pollInterval()) {
+				return
+			}
+			continue
+		}
+
+		if autoConfigURL != lastURL {
+			last = nil
+			lastError = ""
+			lastURL = autoConfigURL
+
+			if _, ok := resolvePacFile(autoConfigURL); autoConfigURL != "" && !ok {
+				printInfo(prefix+"PAC file isn't local, not watching its contents:", autoConfigURL)
+			}
+		}
+
+		path, ok := resolvePacFile(autoConfigURL)
+		if ok {
+			state, err := readPacFile(path, last)
+
+			if err != nil {
+				// Only reported once, until the file can be read again. The
+				// baseline is kept, so a file that's replaced by saving it
+				// still counts as a change
+				if err.Error() != lastError {
+					printError(prefix+"Failed to read PAC file:", err)
+					lastError = err.Error()
+				}
+			} else {
+				if last != nil && state.hash != last.hash && config.logsEvent(EVENT_PAC_CHANGE) {
+					message := prefix + "PAC file contents changed: " + path
+					writeLogEntry(logFile, message)
+					writeSeverityEvent(config, SEVERITY_INFO, message)
+					printEvent(EVENT_PAC_CHANGE, time.Now().Format(config.timestampLayout())+" "+message)
+				}
+
+				last = &state
+				lastError = ""
+			}
+		}
+
+		if !sleepContext(ctx, config.pollInterval()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Name of the file the last known proxy settings are kept in, next to the
+// log file
+const STATE_FILE = "proxy-state.json"
+
+// File access permissions of the state file: we can read/write the file,
+// everyone else can only read it, same as the log file
+const STATE_FILE_PERMISSIONS os.FileMode = 0644
+
+// Last known proxy settings of a hive, as saved in the state file
+type persistedHive struct {
+	ProxyEnable uint64 `json:"proxy_enable"`
+	ProxyServer string `json:"proxy_server"`
+}
+
+// Guards writing the state file, every watcher saves to the same one
+var stateFileMutex sync.Mutex
+
+// Path of the state file, in the same directory as the log file
+func stateFilePath(config Config) string {
+	return filepath.Join(filepath.Dir(config.LogPath), stateFileName)
+}
+
+// Reads the proxy settings saved by the previous run, keyed by the hive. A
+// missing or corrupt file just means there's nothing to compare against
+func loadPersistedState(config Config) map[string]persistedHive {
+	data, err := os.ReadFile(stateFilePath(config))
+	if err != nil {
+		return nil
+	}
+
+	var hives map[string]persistedHive
+	err = json.Unmarshal(data, &hives)
+	if err != nil {
+		printWarning("Ignoring corrupt proxy state file:", err)
+		return nil
+	}
+
+	return hives
+}
+
+// Saves the last known settings of every watcher, so the next run can tell
+// whether the proxy changed while the monitor wasn't running. The file is
+// replaced in one go, so a crash can't leave half of it behind
+func savePersistedState(config Config) {
+	// Made up settings would be compared against the real ones next time
+	if simulateOption {
+		return
+	}
+
+	hives := map[string]persistedHive{}
+
+	watchStatesMutex.Lock()
+	for _, state := range watchStates {
+		state.mutex.Lock()
+		if state.proxyEnable != UNKNOWN_PROXY_ENABLE {
+			hives[state.hive] = persistedHive{ProxyEnable: state.proxyEnable, ProxyServer: state.proxyServer}
+		}
+		state.mutex.Unlock()
+	}
+	watchStatesMutex.Unlock()
+
+	data, err := json.Marshal(hives)
+	if err != nil {
+		printError("Failed to encode proxy state:", err)
+		return
+	}
+
+	stateFileMutex.Lock()
+	defer stateFileMutex.Unlock()
+
+	path := stateFilePath(config)
+	err = os.WriteFile(path+".tmp", data, STATE_FILE_PERMISSIONS)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+
+	if err != nil {
+		printError("Failed to save proxy state:", err)
+	}
+}
+
+// Describes proxy settings for the offline change line, like "off" or
+// "on, 10.0.0.1:8080"
+func describeProxy(proxyEnable uint64, proxyServer string) string {
+	if proxyEnable == 0 || proxyEnable == UNKNOWN_PROXY_ENABLE {
+		return "off"
+	}
+
+	return "on, " + displayProxyServer(proxyServer)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Checks whether the main instance from the PID file is alive and answers on
+// the pipe, and prints a single line about its health. Returns the exit code,
+// 0 if the monitor is running and healthy.
+// Sends the status command, so the monitoring state is never changed
+func pingMonitor() int {
+	pid, pidErr := readPidFile()
+	alive := pidErr == nil && isProcessAlive(pid)
+
+	_, lockErr := os.Stat(lockFileName)
+	locked := lockErr == nil
+
+	pipeErr := pingPipe()
+
+	switch {
+	case pipeErr == nil && alive:
+		fmt.Printf("Monitor is running and healthy, pid=%d\n", pid)
+		return 0
+
+	case pipeErr == nil:
+		// Can happen if the PID file couldn't be written
+		fmt.Println("Monitor is responding, but its PID file is missing or stale")
+		return 0
+
+	case alive:
+		fmt.Printf("Monitor is running, pid=%d, but not responding on the pipe: %v\n", pid, pipeErr)
+		return 1
+
+	case locked && pidErr == nil:
+		fmt.Printf("Stale lock, monitor pid=%d is no longer running\n", pid)
+		return 1
+
+	case locked:
+		fmt.Println("Stale lock, no monitor is running")
+		return 1
+
+	default:
+		fmt.Println("Monitor is not running")
+		return 1
+	}
+}
+
+// Asks the main instance for its status, to see that it actually answers
+func pingPipe() error {
+	conn, err := dialControl(pipeName, PIPE_TIMEOUT)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(PIPE_TIMEOUT))
+	if err != nil {
+		return err
+	}
+
+	response, err := exchangeCommand(conn, command{id: CMD_STATUS})
+	if err != nil {
+		return err
+	}
+
+	if len(response) == 0 {
+		return errors.New("empty response")
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// How long a client waits for the main program instance when connecting,
+// sending a command or reading the response
+const PIPE_TIMEOUT = 5 * time.Second
+
+// How long the pipe listener waits after a failed Accept, doubling with every
+// failure in a row up to the maximum
+const PIPE_ACCEPT_BACKOFF_MIN = 100 * time.Millisecond
+const PIPE_ACCEPT_BACKOFF_MAX = 10 * time.Second
+
+// After this many failed Accepts in a row, the pipe listener is recreated
+const PIPE_ACCEPT_MAX_FAILURES = 5
+
+// How many times a client tries to connect to the main program instance, and
+// how long it waits in between. Covers the short moment the pipe listener is
+// being recreated
+const PIPE_DIAL_ATTEMPTS = 3
+const PIPE_DIAL_RETRY_DELAY = 200 * time.Millisecond
+
+// Response codes the main program instance sends back after executing a
+// command. ALREADY_IN_STATE and OK match the 0 and 1 older versions sent
+const RESPONSE_ALREADY_IN_STATE byte = 0
+const RESPONSE_OK byte = 1
+const RESPONSE_REGISTRY_ERROR byte = 2
+const RESPONSE_INTERNAL_ERROR byte = 3
+const RESPONSE_CONFIG_ERROR byte = 4
+const RESPONSE_WRITES_DISABLED byte = 5
+const RESPONSE_ACCESS_DENIED byte = 6
+
+// Connects to the main program instance, trying again a few times if the
+// connection fails. A timeout isn't retried, the main instance is there but
+// stuck, and waiting for it again would take far too long
+func dialControlWithRetry(name string) (net.Conn, error) {
+	var err error
+
+	for attempt := 1; attempt <= PIPE_DIAL_ATTEMPTS; attempt++ {
+		var conn net.Conn
+		conn, err = dialControl(name, PIPE_TIMEOUT)
+		if err == nil || isTimeout(err) {
+			return conn, err
+		}
+
+		if attempt < PIPE_DIAL_ATTEMPTS {
+			time.Sleep(PIPE_DIAL_RETRY_DELAY)
+		}
+	}
+
+	return nil, err
+}
+
+// Largest frame accepted over the pipe, so a broken or hostile client can't
+// make the server allocate an arbitrary amount of memory
+const MAX_FRAME_SIZE = 1024 * 1024
+
+// Messages sent between instances are framed: a 4 byte big-endian payload
+// length followed by the payload itself. This lets commands and responses
+// carry more than a single byte
+
+// Reads a single frame and returns its payload
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("frame too large: %d bytes", length)
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Writes the payload as a single frame
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MAX_FRAME_SIZE {
+		return fmt.Errorf("frame too large: %d bytes", len(payload))
+	}
+
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// Reports whether the error is from a pipe operation timing out
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Argument of the config command that asks for the secrets too
+const CONFIG_UNREDACTED byte = 1
+
+// Encodes a command as a pipe payload: the command byte, followed by the
+// command's arguments
+func encodeCommand(cmd command) []byte {
+	payload := []byte{cmd.id}
+
+	if cmd.id == CMD_PAUSE {
+		payload = binary.BigEndian.AppendUint64(payload, uint64(cmd.duration))
+	}
+
+	if cmd.id == CMD_ENABLE_PROXY {
+		payload = append(payload, cmd.server...)
+	}
+
+	if cmd.id == CMD_CONFIG && !cmd.redact {
+		payload = append(payload, CONFIG_UNREDACTED)
+	}
+
+	return payload
+}
+
+// Decodes a command sent by encodeCommand
+func decodeCommand(payload []byte) (command, error) {
+	if len(payload) == 0 {
+		return command{}, errors.New("empty command")
+	}
+
+	cmd := command{id: payload[0]}
+	args := payload[1:]
+
+	if cmd.id == CMD_PAUSE {
+		if len(args) != 8 {
+			return command{}, fmt.Errorf("pause command has %d bytes of arguments, expected 8", len(args))
+		}
+
+		cmd.duration = time.Duration(binary.BigEndian.Uint64(args))
+		if cmd.duration <= 0 {
+			return command{}, fmt.Errorf("invalid pause duration: %s", cmd.duration)
+		}
+	}
+
+	// Clients from before the argument only ever get the redacted config
+	if cmd.id == CMD_CONFIG {
+		if len(args) > 1 {
+			return command{}, fmt.Errorf("config command has %d bytes of arguments, expected at most 1", len(args))
+		}
+
+		cmd.redact = len(args) == 0 || args[0] != CONFIG_UNREDACTED
+	}
+
+	if cmd.id == CMD_ENABLE_PROXY {
+		cmd.server = string(args)
+
+		err := validateProxyServerArg(cmd.server)
+		if err != nil {
+			return command{}, err
+		}
+	}
+
+	return cmd, nil
+}
+
+// Reports whether the main program instance answers the command with a
+// response frame. Quit exits before it could, and tail streams log lines
+// instead
+func expectsResponse(id byte) bool {
+	return id != CMD_QUIT && id != CMD_TAIL
+}
+
+// Sends a command frame and reads the response frame, if the command gets
+// one. Both frames are read and written in full, however the pipe splits
+// them up
+func exchangeCommand(conn io.ReadWriter, cmd command) ([]byte, error) {
+	err := writeFrame(conn, encodeCommand(cmd))
+	if err != nil {
+		return nil, err
+	}
+
+	if !expectsResponse(cmd.id) {
+		return nil, nil
+	}
+
+	return readFrame(conn)
+}
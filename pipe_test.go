@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// Starts a pipe listener of its own, with a fresh controller behind it, and
+// returns the pipe's name and the controller
+func startTestPipe(t *testing.T) (string, *Controller) {
+	t.Helper()
+
+	previousController := controller
+	controller = newController()
+	t.Cleanup(func() { controller = previousController })
+
+	name := pipeNameFor(fmt.Sprintf("test%d", os.Getpid()))
+	l, err := listenControl(name)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", name, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		listenToNamedPipe(ctx, l)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return name, controller
+}
+
+// Sends the command the way a client does and returns the response, nil if
+// there was none
+func sendTestCommand(t *testing.T, name string, cmd command) []byte {
+	t.Helper()
+
+	conn, err := dialControlWithRetry(name)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", name, err)
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(PIPE_TIMEOUT))
+	if err != nil {
+		t.Fatalf("Failed to set the pipe timeout: %v", err)
+	}
+
+	response, err := exchangeCommand(conn, cmd)
+	if err != nil {
+		t.Fatalf("Command %d failed: %v", cmd.id, err)
+	}
+
+	// Commands without a response still shouldn't leave anything behind
+	if !expectsResponse(cmd.id) {
+		extra, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("Failed to read past command %d: %v", cmd.id, err)
+		}
+
+		if len(extra) > 0 {
+			t.Errorf("command %d got a response of %d bytes, want none", cmd.id, len(extra))
+		}
+	}
+
+	return response
+}
+
+func TestPipeRoundTrip(t *testing.T) {
+	name, testController := startTestPipe(t)
+
+	tests := []struct {
+		name    string
+		id      byte
+		want    byte
+		enabled bool
+	}{
+		{name: "stop when running", id: CMD_STOP, want: RESPONSE_OK, enabled: false},
+		{name: "stop when stopped", id: CMD_STOP, want: RESPONSE_ALREADY_IN_STATE, enabled: false},
+		{name: "start when stopped", id: CMD_START, want: RESPONSE_OK, enabled: true},
+		{name: "start when running", id: CMD_START, want: RESPONSE_ALREADY_IN_STATE, enabled: true},
+	}
+
+	// Every command runs against the state the one before left behind
+	for _, test := range tests {
+		response := sendTestCommand(t, name, command{id: test.id})
+
+		if len(response) != 1 || response[0] != test.want {
+			t.Errorf("%s: got response %v, want [%d]", test.name, response, test.want)
+		}
+
+		if testController.Enabled() != test.enabled {
+			t.Errorf("%s: monitoring enabled = %t, want %t", test.name, testController.Enabled(), test.enabled)
+		}
+	}
+}
+
+func TestPipeRoundTripQuit(t *testing.T) {
+	name, testController := startTestPipe(t)
+
+	quit := make(chan struct{})
+	testController.shutdown = func() { close(quit) }
+
+	response := sendTestCommand(t, name, command{id: CMD_QUIT})
+	if response != nil {
+		t.Errorf("got response %v to quit, want none", response)
+	}
+
+	select {
+	case <-quit:
+	case <-time.After(PIPE_TIMEOUT):
+		t.Fatal("quitting didn't shut the monitor down")
+	}
+}
+
+func TestPipeRoundTripInvalidCommand(t *testing.T) {
+	name, _ := startTestPipe(t)
+
+	conn, err := dialControlWithRetry(name)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", name, err)
+	}
+	defer conn.Close()
+
+	err = writeFrame(conn, nil)
+	if err != nil {
+		t.Fatalf("Failed to send an empty command: %v", err)
+	}
+
+	// An invalid command is dropped without a response
+	_, err = readFrame(conn)
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("reading the response to an empty command got %v, want io.EOF", err)
+	}
+}
+
+func TestPipeRoundTripConfig(t *testing.T) {
+	name, _ := startTestPipe(t)
+
+	previousConfig := currentConfig()
+	t.Cleanup(func() { setCurrentConfig(previousConfig) })
+
+	config := defaultConfig()
+	config.HTTPToken = "secret-token"
+	config.WebhookURL = "https://hooks.example/secret"
+	setCurrentConfig(config)
+
+	tests := []struct {
+		name    string
+		redact  bool
+		service bool
+		refused bool
+	}{
+		{name: "unredacted", redact: false},
+		{name: "redacted", redact: true},
+		{name: "unredacted as a service", redact: false, service: true, refused: true},
+		{name: "redacted as a service", redact: true, service: true},
+	}
+
+	redacted := redactConfig(config)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			previousService := runningAsService
+			runningAsService = test.service
+			t.Cleanup(func() { runningAsService = previousService })
+
+			response := sendTestCommand(t, name, command{id: CMD_CONFIG, redact: test.redact})
+
+			if test.refused {
+				if len(response) != 1 || response[0] != RESPONSE_ACCESS_DENIED {
+					t.Errorf("got response %q, want [%d]", response, RESPONSE_ACCESS_DENIED)
+				}
+				return
+			}
+
+			want := config
+			if test.redact {
+				want = redacted
+			}
+
+			var got Config
+			err := json.Unmarshal(response, &got)
+			if err != nil {
+				t.Fatalf("Failed to decode the config %q: %v", response, err)
+			}
+
+			if got.HTTPToken != want.HTTPToken || got.WebhookURL != want.WebhookURL {
+				t.Errorf("got http_token %q and webhook_url %q, want %q and %q", got.HTTPToken, got.WebhookURL, want.HTTPToken, want.WebhookURL)
+			}
+		})
+	}
+}
+
+func TestPipeRoundTripClearLogAsService(t *testing.T) {
+	name, _ := startTestPipe(t)
+
+	previousService := runningAsService
+	runningAsService = true
+	t.Cleanup(func() { runningAsService = previousService })
+
+	response := sendTestCommand(t, name, command{id: CMD_CLEARLOG})
+	if len(response) != 1 || response[0] != RESPONSE_ACCESS_DENIED {
+		t.Errorf("got response %v, want [%d]", response, RESPONSE_ACCESS_DENIED)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Key used for a ProxyServer value that isn't split per protocol, in which
+// case the same endpoint is used for all protocols
+const ALL_PROTOCOLS = "all"
+
+// Whitespace around the '=' of a per-protocol entry, like "https = host:443"
+var entrySeparatorSpace = regexp.MustCompile(`\s*=\s*`)
+
+// A single protocol and endpoint of a ProxyServer value
+type proxyEntry struct {
+	protocol string
+	endpoint string
+}
+
+// Splits a ProxyServer registry value into its entries, in the order they're
+// in. A bare "host:port" entry gets ALL_PROTOCOLS as its protocol. Empty and
+// malformed entries are skipped, and clean is false if there were any
+func splitProxyServer(raw string) (entries []proxyEntry, clean bool) {
+	clean = true
+
+	// Otherwise the spaces would split the entry in two
+	raw = entrySeparatorSpace.ReplaceAllString(raw, "=")
+
+	fields := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ';' || r == ' ' || r == '\t'
+	})
+
+	for _, field := range fields {
+		protocol, endpoint, found := strings.Cut(field, "=")
+
+		// No '=' in the entry means it's the bare host:port form
+		if !found {
+			entries = append(entries, proxyEntry{protocol: ALL_PROTOCOLS, endpoint: normalizeEndpoint(field)})
+			continue
+		}
+
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		endpoint = normalizeEndpoint(endpoint)
+
+		if protocol == "" || endpoint == "" {
+			clean = false
+			continue
+		}
+
+		entries = append(entries, proxyEntry{protocol: protocol, endpoint: endpoint})
+	}
+
+	return entries, clean
+}
+
+// Splits a ProxyServer registry value into protocol -> endpoint pairs.
+//
+// The value can either be a bare "host:port", which is used for every
+// protocol, or a list of per-protocol entries delimited by semicolons or
+// spaces, like "http=10.0.0.1:80;https=10.0.0.1:443". Empty and malformed
+// entries are skipped, and a protocol that's given twice keeps the last
+// endpoint, the same as for bare entries.
+func parseProxyServer(raw string) map[string]string {
+	result := make(map[string]string)
+
+	entries, _ := splitProxyServer(raw)
+	for _, entry := range entries {
+		result[entry.protocol] = entry.endpoint
+	}
+
+	return result
+}
+
+// Cleans up a single proxy endpoint. Whitespace and trailing slashes are
+// dropped and the host is lowercased, so "HTTP://Proxy:8080/ " becomes
+// "http://proxy:8080". A scheme is only kept if the value had one
+func normalizeEndpoint(endpoint string) string {
+	endpoint = strings.TrimRight(strings.TrimSpace(endpoint), "/")
+
+	scheme, address, found := strings.Cut(endpoint, "://")
+	if !found {
+		return strings.ToLower(endpoint)
+	}
+
+	// Anything after the host:port is a path, which proxies don't have
+	address, _, _ = strings.Cut(address, "/")
+	return strings.ToLower(scheme) + "://" + strings.ToLower(address)
+}
+
+// Returns the canonical form of a ProxyServer value, as it's written to the
+// log. The same proxy always comes out the same, however it was stored. A
+// value that doesn't parse cleanly, with malformed entries, a protocol given
+// twice or bare entries next to other ones, is only trimmed, so nothing in it
+// is lost
+func normalizeProxyServer(raw string) string {
+	entries, clean := splitProxyServer(raw)
+	servers := parseProxyServer(raw)
+
+	_, bare := servers[ALL_PROTOCOLS]
+	if !clean || len(servers) != len(entries) || (bare && len(entries) > 1) {
+		return strings.TrimSpace(raw)
+	}
+
+	return formatProxyServer(servers)
+}
+
+// Shown in place of the server when the proxy is on without one, like when
+// the ProxyServer value doesn't exist
+const NO_PROXY_SERVER = "no server set"
+
+// Longest server that's shown whole, longer ones are cut off with an ellipsis
+// so a huge ProxyServer value can't bloat every log line
+const MAX_DISPLAYED_SERVER_LENGTH = 256
+
+// Like normalizeProxyServer, but for showing to the user, so an empty server
+// doesn't end up as a dangling "proxy on, ". Anything could have written the
+// value, so it's also made safe to put in a log line
+func displayProxyServer(raw string) string {
+	servers := normalizeProxyServer(raw)
+	if servers == "" {
+		return NO_PROXY_SERVER
+	}
+
+	return sanitizeLogValue(servers, MAX_DISPLAYED_SERVER_LENGTH)
+}
+
+// Escapes the control characters in a value and cuts it off at max
+// characters, ending it with an ellipsis
+func sanitizeLogValue(value string, max int) string {
+	value = escapeControlCharacters(value)
+
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+
+	return string(runes[:max]) + "…"
+}
+
+// Formats a parsed ProxyServer value into a readable, stable string for the
+// log. A proxy that is used for all protocols is written as just the endpoint,
+// per-protocol proxies are written as "http=..., https=..." sorted by protocol
+func formatProxyServer(servers map[string]string) string {
+	if len(servers) == 1 {
+		if endpoint, ok := servers[ALL_PROTOCOLS]; ok {
+			return endpoint
+		}
+	}
+
+	protocols := make([]string, 0, len(servers))
+	for protocol := range servers {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	parts := make([]string, 0, len(protocols))
+	for _, protocol := range protocols {
+		parts = append(parts, protocol+"="+servers[protocol])
+	}
+
+	return strings.Join(parts, ", ")
+}
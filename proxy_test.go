@@ -0,0 +1,201 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProxyServer(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]string
+	}{
+		{
+			name: "bare host and port",
+			raw:  "10.0.0.1:8080",
+			want: map[string]string{ALL_PROTOCOLS: "10.0.0.1:8080"},
+		},
+		{
+			name: "per protocol",
+			raw:  "http=10.0.0.1:80;https=10.0.0.1:443;ftp=10.0.0.2:21",
+			want: map[string]string{"http": "10.0.0.1:80", "https": "10.0.0.1:443", "ftp": "10.0.0.2:21"},
+		},
+		{
+			name: "space delimited",
+			raw:  "http=10.0.0.1:80 https=10.0.0.1:443",
+			want: map[string]string{"http": "10.0.0.1:80", "https": "10.0.0.1:443"},
+		},
+		{
+			name: "spaces around the equals sign",
+			raw:  "https = proxy:443",
+			want: map[string]string{"https": "proxy:443"},
+		},
+		{
+			name: "stray semicolons",
+			raw:  ";;http=10.0.0.1:80;;;https=10.0.0.1:443;",
+			want: map[string]string{"http": "10.0.0.1:80", "https": "10.0.0.1:443"},
+		},
+		{
+			name: "protocol without an endpoint",
+			raw:  "http=;https=10.0.0.1:443",
+			want: map[string]string{"https": "10.0.0.1:443"},
+		},
+		{
+			name: "endpoint without a protocol",
+			raw:  "=10.0.0.1:80;https=10.0.0.1:443",
+			want: map[string]string{"https": "10.0.0.1:443"},
+		},
+		{
+			name: "uppercase protocol",
+			raw:  "HTTP=10.0.0.1:80",
+			want: map[string]string{"http": "10.0.0.1:80"},
+		},
+		{
+			name: "empty",
+			raw:  "",
+			want: map[string]string{},
+		},
+		{
+			name: "only semicolons",
+			raw:  ";;;",
+			want: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseProxyServer(test.raw)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseProxyServer(%q) = %v, want %v", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{endpoint: "10.0.0.1:8080", want: "10.0.0.1:8080"},
+		{endpoint: "  proxy:8080\t", want: "proxy:8080"},
+		{endpoint: "proxy:8080/", want: "proxy:8080"},
+		{endpoint: "proxy:8080///", want: "proxy:8080"},
+		{endpoint: "Proxy.Corp.Example:8080", want: "proxy.corp.example:8080"},
+		{endpoint: "HTTP://Proxy:8080/ ", want: "http://proxy:8080"},
+		{endpoint: "http://proxy:8080/some/path", want: "http://proxy:8080"},
+		{endpoint: "socks5://10.0.0.1:1080", want: "socks5://10.0.0.1:1080"},
+		{endpoint: "", want: ""},
+		{endpoint: " / ", want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.endpoint, func(t *testing.T) {
+			got := normalizeEndpoint(test.endpoint)
+			if got != test.want {
+				t.Errorf("normalizeEndpoint(%q) = %q, want %q", test.endpoint, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeProxyServer(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "bare host and port", raw: "10.0.0.1:8080", want: "10.0.0.1:8080"},
+		{name: "surrounding whitespace", raw: "  10.0.0.1:8080  ", want: "10.0.0.1:8080"},
+		{name: "trailing slash", raw: "proxy:8080/", want: "proxy:8080"},
+		{name: "uppercase host", raw: "PROXY.CORP:8080", want: "proxy.corp:8080"},
+		{name: "scheme and path", raw: "HTTP://Proxy.Corp:8080/pac/", want: "http://proxy.corp:8080"},
+		{
+			name: "per protocol sorted",
+			raw:  "https=10.0.0.1:443;http=10.0.0.1:80;ftp=10.0.0.2:21",
+			want: "ftp=10.0.0.2:21, http=10.0.0.1:80, https=10.0.0.1:443",
+		},
+		{
+			name: "per protocol with schemes",
+			raw:  "HTTP=http://Proxy:80/;HTTPS=https://Proxy:443/",
+			want: "http=http://proxy:80, https=https://proxy:443",
+		},
+		{
+			name: "per protocol with odd whitespace",
+			raw:  " http = 10.0.0.1:80 ;\thttps=10.0.0.1:443 ",
+			want: "http=10.0.0.1:80, https=10.0.0.1:443",
+		},
+		{
+			name: "same proxy stored differently",
+			raw:  "https=10.0.0.1:443 http=10.0.0.1:80/",
+			want: "http=10.0.0.1:80, https=10.0.0.1:443",
+		},
+		{name: "empty", raw: "", want: ""},
+		{name: "only separators", raw: " ; ; ", want: ""},
+		{name: "two bare hosts", raw: " 10.0.0.1:80 10.0.0.2:80 ", want: "10.0.0.1:80 10.0.0.2:80"},
+		{name: "bare host and protocols", raw: "off http=10.0.0.1:80", want: "off http=10.0.0.1:80"},
+		{name: "protocol given twice", raw: "http=10.0.0.1:80;http=10.0.0.2:80", want: "http=10.0.0.1:80;http=10.0.0.2:80"},
+		{name: "protocol without an endpoint", raw: "http=;https=10.0.0.1:443", want: "http=;https=10.0.0.1:443"},
+		{name: "endpoint without a protocol", raw: "=10.0.0.1:80", want: "=10.0.0.1:80"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := normalizeProxyServer(test.raw)
+			if got != test.want {
+				t.Errorf("normalizeProxyServer(%q) = %q, want %q", test.raw, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeLogValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		max   int
+		want  string
+	}{
+		{name: "short enough", value: "10.0.0.1:8080", max: 20, want: "10.0.0.1:8080"},
+		{name: "exactly the maximum", value: "10.0.0.1:8080", max: 13, want: "10.0.0.1:8080"},
+		{name: "too long", value: "10.0.0.1:8080", max: 8, want: "10.0.0.1…"},
+		{name: "cut by characters", value: "prøxy.example:80", max: 3, want: "prø…"},
+		{name: "control characters", value: "a:80\nb\tc", max: 20, want: `a:80\nb\tc`},
+		{name: "escapes count towards the maximum", value: "a:80\nb", max: 6, want: `a:80\n…`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := sanitizeLogValue(test.value, test.max)
+			if got != test.want {
+				t.Errorf("sanitizeLogValue(%q, %d) = %q, want %q", test.value, test.max, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDisplayProxyServer(t *testing.T) {
+	long := strings.Repeat("a", MAX_DISPLAYED_SERVER_LENGTH+100) + ":8080"
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "regular", raw: "10.0.0.1:8080", want: "10.0.0.1:8080"},
+		{name: "empty", raw: "", want: NO_PROXY_SERVER},
+		{name: "newline", raw: "10.0.0.1:8080\nfake", want: `10.0.0.1:8080\nfake`},
+		{name: "too long", raw: long, want: long[:MAX_DISPLAYED_SERVER_LENGTH] + "…"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := displayProxyServer(test.raw)
+			if got != test.want {
+				t.Errorf("displayProxyServer(%q) = %q, want %q", test.raw, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Package proxymon watches the Windows proxy settings in the registry for
+// changes. It's the core of the proxy-monitor program, usable on its own by
+// other Go programs:
+//
+//	watcher := proxymon.NewWatcher(proxymon.HIVE_HKCU)
+//	events, err := watcher.Start(ctx)
+//	if err != nil {
+//		return err
+//	}
+//
+//	for event := range events {
+//		fmt.Println(event.Time, event.Enabled, event.Server)
+//	}
+//
+// Outside of Windows, the registry is replaced by an in-memory fake, so code
+// using the package can be built and tested anywhere
+package proxymon
+
+import "strings"
+
+// Path of the Internet Settings registry key, relative to the hive
+const INTERNET_SETTINGS_KEY = `SOFTWARE\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// Path of the key Group Policy enforces Internet Settings in, relative to the
+// hive. Values set there take precedence over the user's own settings
+const POLICY_INTERNET_SETTINGS_KEY = `SOFTWARE\Policies\Microsoft\Windows\CurrentVersion\Internet Settings`
+
+// A registry hive that can be monitored
+type Hive string
+
+// The current user's and the machine-wide settings
+const HIVE_HKCU Hive = "HKCU"
+const HIVE_HKLM Hive = "HKLM"
+
+// Prefix of the hives of other user profiles, loaded under HKEY_USERS. The
+// rest of the name is the profile's SID, like HKU\S-1-5-21-...
+const USER_HIVE_PREFIX = `HKU\`
+
+// The hive of the user profile with the given SID, under HKEY_USERS
+func UserHive(sid string) Hive {
+	return Hive(USER_HIVE_PREFIX + sid)
+}
+
+// Returns the SID of a user profile's hive, false for HKCU and HKLM
+func (h Hive) UserSID() (string, bool) {
+	if !strings.HasPrefix(string(h), USER_HIVE_PREFIX) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(string(h), USER_HIVE_PREFIX), true
+}
+
+// Reports whether a key directly under HKEY_USERS is a user profile. The
+// _Classes keys next to every profile aren't, and neither is .DEFAULT, the
+// profile new users start out with
+func isUserProfileKey(name string) bool {
+	return strings.HasPrefix(name, "S-1-") && !strings.HasSuffix(name, "_Classes")
+}
+
+// Read access to a registry key. On Windows this is the real registry.Key,
+// elsewhere it's backed by an in-memory fake
+type Key interface {
+	GetIntegerValue(name string) (uint64, uint32, error)
+	GetStringValue(name string) (string, uint32, error)
+	GetBinaryValue(name string) ([]byte, uint32, error)
+	GetStringsValue(name string) ([]string, uint32, error)
+	ReadValueNames(n int) ([]string, error)
+	Close() error
+}
+
+// Write access to a registry key, opened with OpenKeyForWriting
+type WritableKey interface {
+	SetDWordValue(name string, value uint32) error
+	SetStringValue(name string, value string) error
+	Close() error
+}
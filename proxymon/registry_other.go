@@ -0,0 +1,244 @@
+//go:build !windows
+
+package proxymon
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Returned when a registry key or value doesn't exist
+var ErrNotExist = errors.New("registry key or value does not exist")
+
+// Returned when reading a value as a different type than it was set as
+var ErrUnexpectedType = errors.New("unexpected registry value type")
+
+// In-memory stand-in for the registry, keyed by the hive and key path, then
+// by the value name. Like the real registry, names are case-insensitive
+var fakeRegistry = map[string]map[string]any{}
+var fakeRegistryMutex sync.Mutex
+
+// Value names the way they were set, for listing the values of a key. Keyed
+// the same way as fakeRegistry
+var fakeValueNames = map[string]map[string]string{}
+
+// The fake registry starts out like a fresh install, with the proxy turned
+// off for both hives, so the monitor has something to watch
+func init() {
+	SetFakeValue(HIVE_HKCU, INTERNET_SETTINGS_KEY, "ProxyEnable", uint32(0))
+	SetFakeValue(HIVE_HKLM, INTERNET_SETTINGS_KEY, "ProxyEnable", uint32(0))
+}
+
+// Key in the fake registry. Values are looked up on every read, so changes
+// made while the key is open are seen, same as with a real key
+type fakeRegistryKey struct {
+	path string
+}
+
+func fakeRegistryPath(hive Hive, path string) string {
+	return string(hive) + `\` + strings.ToLower(path)
+}
+
+// Sets a value in the fake registry, creating the key if needed. Integers
+// have to be uint32 or uint64, like DWORD and QWORD values. Only exists
+// outside of Windows, for simulating proxy changes in tests
+func SetFakeValue(hive Hive, path string, name string, value any) {
+	fakeRegistryMutex.Lock()
+	defer fakeRegistryMutex.Unlock()
+
+	keyPath := fakeRegistryPath(hive, path)
+	if fakeRegistry[keyPath] == nil {
+		fakeRegistry[keyPath] = map[string]any{}
+		fakeValueNames[keyPath] = map[string]string{}
+	}
+
+	fakeRegistry[keyPath][strings.ToLower(name)] = value
+	fakeValueNames[keyPath][strings.ToLower(name)] = name
+}
+
+// Removes a value from the fake registry. Only exists outside of Windows
+func DeleteFakeValue(hive Hive, path string, name string) {
+	fakeRegistryMutex.Lock()
+	defer fakeRegistryMutex.Unlock()
+
+	keyPath := fakeRegistryPath(hive, path)
+	delete(fakeRegistry[keyPath], strings.ToLower(name))
+	delete(fakeValueNames[keyPath], strings.ToLower(name))
+}
+
+// Opens a key in the fake registry, which has to have been created by setting
+// a value in it first
+func OpenKey(hive Hive, path string) (Key, error) {
+	fakeRegistryMutex.Lock()
+	defer fakeRegistryMutex.Unlock()
+
+	keyPath := fakeRegistryPath(hive, path)
+	if fakeRegistry[keyPath] == nil {
+		return nil, ErrNotExist
+	}
+
+	return &fakeRegistryKey{path: keyPath}, nil
+}
+
+// Opens a key in the fake registry for setting values. Like the real
+// registry, the key has to exist already
+func OpenKeyForWriting(hive Hive, path string) (WritableKey, error) {
+	key, err := OpenKey(hive, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.(*fakeRegistryKey), nil
+}
+
+// There are no other programs to tell about changes to the fake registry
+func notifySettingsChanged() {}
+
+func (k *fakeRegistryKey) getValue(name string) (any, error) {
+	fakeRegistryMutex.Lock()
+	defer fakeRegistryMutex.Unlock()
+
+	value, ok := fakeRegistry[k.path][strings.ToLower(name)]
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	return value, nil
+}
+
+// The value types aren't tracked, so the type is always returned as 0
+func (k *fakeRegistryKey) GetIntegerValue(name string) (uint64, uint32, error) {
+	value, err := k.getValue(name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	switch number := value.(type) {
+	case uint32:
+		return uint64(number), 0, nil
+	case uint64:
+		return number, 0, nil
+	default:
+		return 0, 0, ErrUnexpectedType
+	}
+}
+
+func (k *fakeRegistryKey) GetStringValue(name string) (string, uint32, error) {
+	value, err := k.getValue(name)
+	if err != nil {
+		return "", 0, err
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return "", 0, ErrUnexpectedType
+	}
+
+	return str, 0, nil
+}
+
+func (k *fakeRegistryKey) GetBinaryValue(name string) ([]byte, uint32, error) {
+	value, err := k.getValue(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, 0, ErrUnexpectedType
+	}
+
+	return data, 0, nil
+}
+
+func (k *fakeRegistryKey) GetStringsValue(name string) ([]string, uint32, error) {
+	value, err := k.getValue(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	strs, ok := value.([]string)
+	if !ok {
+		return nil, 0, ErrUnexpectedType
+	}
+
+	return strs, 0, nil
+}
+
+// Lists the names of the key's values, in no particular order. Returns at
+// most n names, or all of them if n is 0 or less, same as the real registry
+func (k *fakeRegistryKey) ReadValueNames(n int) ([]string, error) {
+	fakeRegistryMutex.Lock()
+	defer fakeRegistryMutex.Unlock()
+
+	names := []string{}
+	for _, name := range fakeValueNames[k.path] {
+		if n > 0 && len(names) >= n {
+			break
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (k *fakeRegistryKey) setValue(name string, value any) error {
+	fakeRegistryMutex.Lock()
+	defer fakeRegistryMutex.Unlock()
+
+	if fakeRegistry[k.path] == nil {
+		return ErrNotExist
+	}
+
+	fakeRegistry[k.path][strings.ToLower(name)] = value
+	fakeValueNames[k.path][strings.ToLower(name)] = name
+	return nil
+}
+
+func (k *fakeRegistryKey) SetDWordValue(name string, value uint32) error {
+	return k.setValue(name, value)
+}
+
+func (k *fakeRegistryKey) SetStringValue(name string, value string) error {
+	return k.setValue(name, value)
+}
+
+func (k *fakeRegistryKey) Close() error {
+	return nil
+}
+
+// Lists the user profile hives that have any keys in the fake registry,
+// sorted by their SID
+func LoadedUserHives() ([]Hive, error) {
+	fakeRegistryMutex.Lock()
+	defer fakeRegistryMutex.Unlock()
+
+	seen := map[string]bool{}
+	for keyPath := range fakeRegistry {
+		rest, found := strings.CutPrefix(keyPath, USER_HIVE_PREFIX)
+		if !found {
+			continue
+		}
+
+		sid, _, _ := strings.Cut(rest, `\`)
+		if isUserProfileKey(sid) {
+			seen[sid] = true
+		}
+	}
+
+	sids := []string{}
+	for sid := range seen {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+
+	hives := []Hive{}
+	for _, sid := range sids {
+		hives = append(hives, UserHive(sid))
+	}
+
+	return hives, nil
+}
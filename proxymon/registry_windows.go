@@ -0,0 +1,103 @@
+//go:build windows
+
+package proxymon
+
+import (
+	"golang.org/x/sys/windows"
+
+	// Registry access API
+	"golang.org/x/sys/windows/registry"
+)
+
+// Options for InternetSetOption that make running programs re-read the proxy
+// settings from the registry
+const INTERNET_OPTION_SETTINGS_CHANGED = 39
+const INTERNET_OPTION_REFRESH = 37
+
+var wininet = windows.NewLazySystemDLL("wininet.dll")
+var internetSetOption = wininet.NewProc("InternetSetOptionW")
+
+// Returned when a registry key or value doesn't exist
+var ErrNotExist = registry.ErrNotExist
+
+// Returned when reading a value as a different type than it's stored as
+var ErrUnexpectedType = registry.ErrUnexpectedType
+
+// Opens a key in the hive for reading values
+func OpenKey(hive Hive, path string) (Key, error) {
+	root, path := hiveRoot(hive, path)
+
+	key, err := registry.OpenKey(root, path, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Opens a key in the hive for setting values. Writing to HKLM or another
+// user's hive needs administrator rights
+func OpenKeyForWriting(hive Hive, path string) (WritableKey, error) {
+	root, path := hiveRoot(hive, path)
+
+	key, err := registry.OpenKey(root, path, registry.WRITE)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Finds the root key a hive's path is under. User profiles are under
+// HKEY_USERS, so their SID is added to the front of the path
+func hiveRoot(hive Hive, path string) (registry.Key, string) {
+	root := registry.CURRENT_USER
+	if hive == HIVE_HKLM {
+		root = registry.LOCAL_MACHINE
+	}
+
+	sid, ok := hive.UserSID()
+	if ok {
+		root = registry.USERS
+		path = sid + `\` + path
+	}
+
+	return root, path
+}
+
+// Tells running programs, like browsers, that the proxy settings changed, so
+// they don't keep using the old ones. Failing is harmless, they'll still see
+// the change the next time they read the settings
+func notifySettingsChanged() {
+	if internetSetOption.Find() != nil {
+		return
+	}
+
+	internetSetOption.Call(0, INTERNET_OPTION_SETTINGS_CHANGED, 0, 0)
+	internetSetOption.Call(0, INTERNET_OPTION_REFRESH, 0, 0)
+}
+
+// Lists the hives of the user profiles that are currently loaded under
+// HKEY_USERS, which are the users that are logged on, along with the service
+// accounts
+func LoadedUserHives() ([]Hive, error) {
+	users, err := registry.OpenKey(registry.USERS, "", registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil, err
+	}
+	defer users.Close()
+
+	names, err := users.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	hives := []Hive{}
+	for _, name := range names {
+		if isUserProfileKey(name) {
+			hives = append(hives, UserHive(name))
+		}
+	}
+
+	return hives, nil
+}
@@ -0,0 +1,114 @@
+package proxymon
+
+import "errors"
+
+// Proxy settings of a single hive
+type State struct {
+	Hive Hive
+
+	// Whether ProxyEnable is set
+	Enabled bool
+
+	// Raw ProxyServer value, either a single host:port or per-protocol
+	// entries like http=host:port;https=host:port
+	Server string
+
+	// Raw ProxyOverride value, the hosts that bypass the proxy
+	Override string
+}
+
+// Reads the proxy settings from an open Internet Settings key. Values that
+// aren't set are left empty
+func ReadState(key Key, hive Hive) (State, error) {
+	state := State{Hive: hive}
+
+	proxyEnable, _, err := key.GetIntegerValue("ProxyEnable")
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return state, err
+	}
+	state.Enabled = proxyEnable != 0
+
+	state.Server, _, err = key.GetStringValue("ProxyServer")
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return state, err
+	}
+
+	state.Override, _, err = key.GetStringValue("ProxyOverride")
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return state, err
+	}
+
+	return state, nil
+}
+
+// Reads the current proxy settings of a hive straight from the registry
+func CurrentState(hive Hive) (State, error) {
+	key, err := OpenKey(hive, INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return State{Hive: hive}, err
+	}
+	defer key.Close()
+
+	return ReadState(key, hive)
+}
+
+// Turns the hive's proxy on or off. The server is only written when turning
+// the proxy on, turning it off leaves the last server in place, the same as
+// the Windows settings do. Programs pick the change up the next time they
+// read the settings, on Windows they're also told to right away
+func SetProxy(hive Hive, enabled bool, server string) error {
+	key, err := OpenKeyForWriting(hive, INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	if enabled {
+		err = key.SetStringValue("ProxyServer", server)
+		if err != nil {
+			return err
+		}
+	}
+
+	proxyEnable := uint32(0)
+	if enabled {
+		proxyEnable = 1
+	}
+
+	err = key.SetDWordValue("ProxyEnable", proxyEnable)
+	if err != nil {
+		return err
+	}
+
+	notifySettingsChanged()
+	return nil
+}
+
+// Writes both proxy settings back the way they were in the state, like after
+// SetProxy turned the proxy on with another server. Unlike SetProxy, the
+// server is written even when the proxy is off
+func RestoreProxy(state State) error {
+	key, err := OpenKeyForWriting(state.Hive, INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	err = key.SetStringValue("ProxyServer", state.Server)
+	if err != nil {
+		return err
+	}
+
+	proxyEnable := uint32(0)
+	if state.Enabled {
+		proxyEnable = 1
+	}
+
+	err = key.SetDWordValue("ProxyEnable", proxyEnable)
+	if err != nil {
+		return err
+	}
+
+	notifySettingsChanged()
+	return nil
+}
@@ -0,0 +1,188 @@
+package proxymon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often a watcher checks the registry, unless set otherwise
+const DEFAULT_POLL_INTERVAL = time.Second
+
+// How many checks in a row can fail to read the registry before a watcher
+// gives up and closes its event channel. The key is reopened after every
+// failure
+const MAX_READ_FAILURES = 5
+
+// A change to the proxy settings, or the initial settings when a watcher
+// starts
+type Event struct {
+	State
+
+	// When the change was seen
+	Time time.Time
+}
+
+// Where a watcher reads the proxy settings from, when it shouldn't be the
+// hive's Internet Settings key
+type Reader interface {
+	ReadState() (State, error)
+
+	// Starts over after reading failed, in case the source went bad
+	Reopen() error
+}
+
+// Watches the proxy settings of a single hive by polling the registry
+type Watcher struct {
+	// How often the registry is checked, changing it after Start has no
+	// effect
+	PollInterval time.Duration
+
+	// Read instead of the hive's Internet Settings key when set. The watcher
+	// never closes it, that's up to whoever created it
+	Reader Reader
+
+	// Picks the wait before the next check instead of PollInterval when
+	// set, given whether the last check saw a change
+	NextInterval func(changed bool) time.Duration
+
+	// Checks are skipped for as long as it returns true. The settings are
+	// sent again once it doesn't anymore
+	Paused func() bool
+
+	hive Hive
+
+	mutex    sync.Mutex
+	current  State
+	hasState bool
+}
+
+// Creates a watcher for the hive's proxy settings
+func NewWatcher(hive Hive) *Watcher {
+	return &Watcher{PollInterval: DEFAULT_POLL_INTERVAL, hive: hive}
+}
+
+// Starts watching the proxy settings. The current settings are sent as the
+// first event, after that every change is. The channel is closed when the
+// context is cancelled, or when reading the registry keeps failing
+func (w *Watcher) Start(ctx context.Context) (<-chan Event, error) {
+	events := make(chan Event)
+
+	if w.Reader != nil {
+		go w.run(ctx, w.Reader, events)
+		return events, nil
+	}
+
+	key, err := OpenKey(w.hive, INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &keyReader{hive: w.hive, key: key}
+	go func() {
+		defer func() { reader.key.Close() }()
+		w.run(ctx, reader, events)
+	}()
+
+	return events, nil
+}
+
+// Forgets the last settings, so the next check sends them again even if
+// they haven't changed
+func (w *Watcher) Reset() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.hasState = false
+}
+
+// Returns the last settings the watcher has seen. Before the first check,
+// the settings are read straight from the registry
+func (w *Watcher) CurrentState() (State, error) {
+	w.mutex.Lock()
+	current, hasState := w.current, w.hasState
+	w.mutex.Unlock()
+
+	if hasState {
+		return current, nil
+	}
+
+	return CurrentState(w.hive)
+}
+
+func (w *Watcher) run(ctx context.Context, reader Reader, events chan<- Event) {
+	defer close(events)
+
+	failures := 0
+
+	for {
+		changed := false
+
+		if w.Paused != nil && w.Paused() {
+			w.Reset()
+		} else {
+			state, err := reader.ReadState()
+
+			if err != nil {
+				failures++
+				if failures >= MAX_READ_FAILURES {
+					return
+				}
+
+				// The handle may have gone bad, try a fresh one. Reading
+				// again will tell if that didn't work
+				_ = reader.Reopen()
+			} else {
+				failures = 0
+
+				w.mutex.Lock()
+				changed = !w.hasState || state != w.current
+				w.current = state
+				w.hasState = true
+				w.mutex.Unlock()
+
+				if changed {
+					select {
+					case events <- Event{State: state, Time: time.Now()}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+
+		interval := w.PollInterval
+		if w.NextInterval != nil {
+			interval = w.NextInterval(changed)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reads the settings from a hive's Internet Settings key
+type keyReader struct {
+	hive Hive
+	key  Key
+}
+
+func (r *keyReader) ReadState() (State, error) {
+	return ReadState(r.key, r.hive)
+}
+
+// Replaces the key with a freshly opened one. The old key is kept if the new
+// one can't be opened
+func (r *keyReader) Reopen() error {
+	newKey, err := OpenKey(r.hive, INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return err
+	}
+
+	r.key.Close()
+	r.key = newKey
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Turns the proxy on with the server, or off, in every hive from the config,
+// and returns one of the response codes. Only allowed with allow_writes, the
+// monitor doesn't touch the settings otherwise. The watchers are told first,
+// so the change is logged as the monitor's own
+func setProxy(config Config, enabled bool, server string) byte {
+	if !config.AllowWrites {
+		printWarning("Refusing to change the proxy settings, allow_writes is off in the config")
+		return RESPONSE_WRITES_DISABLED
+	}
+
+	hives, err := parseRegistryHives(config.RegistryHive)
+	if err != nil {
+		printError("Failed to find the hives to change the proxy settings of:", err)
+		return RESPONSE_REGISTRY_ERROR
+	}
+
+	// Everything is read first, so a hive that can't be read doesn't leave
+	// the ones before it changed
+	states := []proxymon.State{}
+	for _, hive := range hives {
+		current, err := proxymon.CurrentState(hive)
+		if err != nil {
+			printErrorf("Failed to read the proxy settings of %s: %v\n", hive, err)
+			return RESPONSE_REGISTRY_ERROR
+		}
+
+		states = append(states, current)
+	}
+
+	// Settings of the hives that were already changed, put back if a later
+	// hive fails, so they don't end up with different proxies
+	changed := []proxymon.State{}
+
+	for _, current := range states {
+		// Turning it off keeps the server, so it's what the watcher sees
+		target := server
+		if !enabled {
+			target = current.Server
+		}
+
+		if current.Enabled == enabled && current.Server == target {
+			continue
+		}
+
+		err := writeProxySettings(current.Hive, enabled, target)
+		if err != nil {
+			printErrorf("Failed to change the proxy settings of %s: %v\n", current.Hive, err)
+			rollBackProxySettings(changed)
+			return RESPONSE_REGISTRY_ERROR
+		}
+
+		changed = append(changed, current)
+	}
+
+	if len(changed) == 0 {
+		return RESPONSE_ALREADY_IN_STATE
+	}
+
+	return RESPONSE_OK
+}
+
+// Writes the proxy settings to the hive, telling the watcher first so the
+// change is logged as the monitor's own
+func writeProxySettings(hive proxymon.Hive, enabled bool, server string) error {
+	proxyEnable := uint64(0)
+	if enabled {
+		proxyEnable = 1
+	}
+	expectSelfChange(string(hive), proxyEnable, server)
+
+	return proxymon.SetProxy(hive, enabled, server)
+}
+
+// Puts back the settings the hives had before they were changed. Every hive
+// that can't be put back is reported, since it's now left changed
+func rollBackProxySettings(previous []proxymon.State) {
+	for _, state := range previous {
+		proxyEnable := uint64(0)
+		if state.Enabled {
+			proxyEnable = 1
+		}
+		expectSelfChange(string(state.Hive), proxyEnable, state.Server)
+
+		err := proxymon.RestoreProxy(state)
+		if err != nil {
+			printErrorf("Failed to put back the proxy settings of %s, it's left changed: %v\n", state.Hive, err)
+			continue
+		}
+
+		printInfof("Put back the proxy settings of %s\n", state.Hive)
+	}
+}
+
+// Checks the server given to -enable-proxy, which is written to the registry
+// as is, so it can also be a per-protocol list like http=host:port;https=...
+func validateProxyServerArg(server string) error {
+	if strings.TrimSpace(server) == "" {
+		return fmt.Errorf("empty proxy server")
+	}
+
+	if strings.ContainsAny(server, " \t\r\n") {
+		return fmt.Errorf("proxy server can't contain spaces: %s", server)
+	}
+
+	return nil
+}
+
+// Carries out -enable-proxy or -disable-proxy in this process, for when no
+// monitor is running to hand it to. Returns the exit code
+func setProxyLocally(cmd command) int {
+	code := setProxy(loadConfig(), cmd.id == CMD_ENABLE_PROXY, cmd.server)
+	fmt.Println(responseMessage(cmd, code))
+
+	if code == RESPONSE_OK || code == RESPONSE_ALREADY_IN_STATE {
+		return 0
+	}
+
+	return 1
+}
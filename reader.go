@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Where a watcher reads the proxy settings from. Usually the Internet
+// Settings key, but anything that can hand out the two values works, so the
+// change detection doesn't depend on the registry
+type proxyReader interface {
+	ProxyEnable() (uint64, error)
+	ProxyServer() (string, error)
+
+	// Where the settings from the last read came from, empty when they're
+	// the user's own
+	Source() string
+
+	// Starts over after reading failed, in case the source went bad
+	Reopen() error
+	Close() error
+}
+
+// Sources of the proxy settings other than the user's own. Names of the
+// policy keys, as shown in verbose output
+const SOURCE_MACHINE_POLICY = "machine policy"
+const SOURCE_USER_POLICY = "user policy"
+
+// A Group Policy key that can enforce the proxy settings
+type policySource struct {
+	hive proxymon.Hive
+	name string
+}
+
+// Reads the proxy settings from a hive's Internet Settings key, unless Group
+// Policy enforces them, in which case the policy key wins
+type registryProxyReader struct {
+	mutex sync.Mutex
+	hive  proxymon.Hive
+	key   proxymon.Key
+
+	// Policy key the last ProxyEnable came from, nil for the hive's own key.
+	// ProxyServer is read from the same place
+	source *policySource
+}
+
+// Creates a reader for the hive's Internet Settings key. Takes ownership of
+// the key, which has to be opened already
+func newRegistryProxyReader(hive proxymon.Hive, key proxymon.Key) *registryProxyReader {
+	return &registryProxyReader{hive: hive, key: key}
+}
+
+// Policy keys that can enforce the hive's proxy settings, in the order they
+// take precedence. The machine policy applies to every user
+func (r *registryProxyReader) policySources() []policySource {
+	sources := []policySource{{hive: proxymon.HIVE_HKLM, name: SOURCE_MACHINE_POLICY}}
+	if r.hive != proxymon.HIVE_HKLM {
+		sources = append(sources, policySource{hive: r.hive, name: SOURCE_USER_POLICY})
+	}
+
+	return sources
+}
+
+func (r *registryProxyReader) ProxyEnable() (uint64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Policy keys rarely exist, so they're only opened for the read
+	for _, source := range r.policySources() {
+		proxyEnable, err := readPolicyValue(source, func(key proxymon.Key) (uint64, error) {
+			value, _, err := key.GetIntegerValue("ProxyEnable")
+			return value, err
+		})
+
+		if errors.Is(err, proxymon.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", source.name, err)
+		}
+
+		r.source = &source
+		return proxyEnable, nil
+	}
+
+	r.source = nil
+	proxyEnable, _, err := r.key.GetIntegerValue("ProxyEnable")
+	return proxyEnable, err
+}
+
+func (r *registryProxyReader) ProxyServer() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.source != nil {
+		return readPolicyValue(*r.source, func(key proxymon.Key) (string, error) {
+			value, _, err := key.GetStringValue("ProxyServer")
+			return value, err
+		})
+	}
+
+	proxyServer, _, err := r.key.GetStringValue("ProxyServer")
+	return proxyServer, err
+}
+
+func (r *registryProxyReader) Source() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.source == nil {
+		return ""
+	}
+
+	return r.source.name
+}
+
+// Opens the policy key, reads a value from it and closes it again. Returns
+// ErrNotExist if either the key or the value doesn't exist
+func readPolicyValue[T any](source policySource, read func(proxymon.Key) (T, error)) (T, error) {
+	var zero T
+
+	key, err := proxymon.OpenKey(source.hive, proxymon.POLICY_INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return zero, err
+	}
+	defer key.Close()
+
+	return read(key)
+}
+
+// Replaces the key with a freshly opened one. The old key is kept if the new
+// one can't be opened
+func (r *registryProxyReader) Reopen() error {
+	newKey, err := proxymon.OpenKey(r.hive, proxymon.INTERNET_SETTINGS_KEY)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.key.Close()
+	r.key = newKey
+	return nil
+}
+
+func (r *registryProxyReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.key.Close()
+}
@@ -0,0 +1,21 @@
+package main
+
+// Name the monitor is registered under with the Service Control Manager
+const SERVICE_NAME = "ProxyMonitor"
+const SERVICE_DISPLAY_NAME = "Proxy Monitor"
+const SERVICE_DESCRIPTION = "Logs changes to the Windows proxy settings."
+
+// Set when the process was started by the Service Control Manager
+var runningAsService bool = false
+
+// Receives a request to stop the service, like from -quit. Only the service
+// handler can tell the SCM it stopped, so it has to do the stopping
+var serviceStopRequests = make(chan struct{}, 1)
+
+// Asks the service handler to stop the service, without waiting for it
+func requestServiceStop() {
+	select {
+	case serviceStopRequests <- struct{}{}:
+	default:
+	}
+}
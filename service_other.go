@@ -0,0 +1,24 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// Returned by the service commands on platforms without Windows services
+var errServicesUnsupported = errors.New("services are only supported on Windows")
+
+// There's no Service Control Manager outside of Windows
+func isWindowsService() (bool, error) {
+	return false, nil
+}
+
+func installService() error {
+	return errServicesUnsupported
+}
+
+func uninstallService() error {
+	return errServicesUnsupported
+}
+
+// Never called, since isWindowsService always returns false
+func serviceMain() {}
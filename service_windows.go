@@ -0,0 +1,141 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Security descriptor of the named pipe when running as a service. The
+// service runs as LocalSystem, so without this regular users couldn't
+// connect to the pipe to send commands
+const SERVICE_PIPE_SECURITY = "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;AU)"
+
+// Handles requests from the Service Control Manager
+type monitorService struct{}
+
+// Reports whether the process was started by the Service Control Manager
+func isWindowsService() (bool, error) {
+	return svc.IsWindowsService()
+}
+
+// Registers the current executable as a service that starts at boot
+func installService() error {
+	exePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	service, err := m.OpenService(SERVICE_NAME)
+	if err == nil {
+		service.Close()
+		return fmt.Errorf("service %s is already installed", SERVICE_NAME)
+	}
+
+	service, err = m.CreateService(SERVICE_NAME, exePath, mgr.Config{
+		DisplayName: SERVICE_DISPLAY_NAME,
+		Description: SERVICE_DESCRIPTION,
+		StartType:   mgr.StartAutomatic,
+	})
+	if err != nil {
+		return err
+	}
+
+	service.Close()
+	return nil
+}
+
+// Removes the service registration
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	service, err := m.OpenService(SERVICE_NAME)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", SERVICE_NAME)
+	}
+	defer service.Close()
+
+	return service.Delete()
+}
+
+// Entry point when running under the Service Control Manager. Blocks until
+// the service is stopped
+func serviceMain() {
+	runningAsService = true
+
+	err := svc.Run(SERVICE_NAME, &monitorService{})
+	if err != nil {
+		printError("Failed to run service:", err)
+	}
+}
+
+// Runs the monitor the same way serverMain does, minus the tray, and maps the
+// SCM's stop and shutdown requests to the regular shutdown path
+func (s *monitorService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	config := loadConfig()
+	setCurrentConfig(config)
+
+	err := validateLogPath(config.logFilePath(time.Now()))
+	if err != nil {
+		printErrorf("Log file %s is not writable: %v\n", config.LogPath, err)
+		return false, 1
+	}
+
+	err = startControlServers(config)
+	if err != nil {
+		printError("Exiting, the service wouldn't be reachable:", err)
+		return false, 1
+	}
+
+	// The monitor only stops on its own when something failed
+	monitorDone := make(chan struct{})
+	go func() {
+		listenToProxyChanges(rootContext, config)
+		close(monitorDone)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case <-monitorDone:
+			status <- svc.Status{State: svc.StopPending}
+			runShutdownHooks()
+			return false, 1
+
+		// Quit was sent over the pipe or the HTTP server
+		case <-serviceStopRequests:
+			status <- svc.Status{State: svc.StopPending}
+			runShutdownHooks()
+			return false, 0
+
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				runShutdownHooks()
+				return false, 0
+			}
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "context"
+
+// There's no session to lock outside of Windows, so monitoring is never
+// paused for it
+func watchSessionLock(ctx context.Context) {}
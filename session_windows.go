@@ -0,0 +1,186 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32   = windows.NewLazySystemDLL("user32.dll")
+	wtsapi32 = windows.NewLazySystemDLL("wtsapi32.dll")
+
+	procRegisterClassExW                 = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW                  = user32.NewProc("CreateWindowExW")
+	procDefWindowProcW                   = user32.NewProc("DefWindowProcW")
+	procGetMessageW                      = user32.NewProc("GetMessageW")
+	procDispatchMessageW                 = user32.NewProc("DispatchMessageW")
+	procWTSRegisterSessionNotification   = wtsapi32.NewProc("WTSRegisterSessionNotification")
+	procWTSUnRegisterSessionNotification = wtsapi32.NewProc("WTSUnRegisterSessionNotification")
+)
+
+// Window message sent when the session is locked, unlocked, logged on to and
+// so on, the kind of change is in wParam
+const WM_WTSSESSION_CHANGE = 0x02B1
+const WTS_SESSION_LOCK = 0x7
+const WTS_SESSION_UNLOCK = 0x8
+
+// Only get notified about the session the monitor is running in
+const NOTIFY_FOR_THIS_SESSION = 0
+
+// Parent of a message-only window, one that's never shown and only receives
+// messages. (HWND)-3 in C
+const HWND_MESSAGE = ^uintptr(2)
+
+// Name the session window's class is registered under
+const SESSION_WINDOW_CLASS = "ProxyMonitorSessionWindow"
+
+// WNDCLASSEXW
+type wndClassEx struct {
+	size       uint32
+	style      uint32
+	wndProc    uintptr
+	clsExtra   int32
+	wndExtra   int32
+	instance   windows.Handle
+	icon       windows.Handle
+	cursor     windows.Handle
+	background windows.Handle
+	menuName   *uint16
+	className  *uint16
+	iconSm     windows.Handle
+}
+
+// MSG
+type windowMessage struct {
+	hwnd     uintptr
+	message  uint32
+	wParam   uintptr
+	lParam   uintptr
+	time     uint32
+	x        int32
+	y        int32
+	lPrivate uint32
+}
+
+// Pauses monitoring while the session is locked and resumes it once it's
+// unlocked, when pause_when_locked is on. Session notifications are only sent
+// to windows, so a hidden message-only window is created for them. Runs the
+// window's message loop until the context is cancelled, or setting up the
+// window fails
+func watchSessionLock(ctx context.Context) {
+	// Messages go to the thread that created the window
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	threadID := windows.GetCurrentThreadId()
+
+	hwnd, err := createSessionWindow()
+	if err != nil {
+		printError("Failed to watch for the session being locked:", err)
+		return
+	}
+
+	ok, _, err := procWTSRegisterSessionNotification.Call(hwnd, NOTIFY_FOR_THIS_SESSION)
+	if ok == 0 {
+		printError("Failed to watch for the session being locked:", err)
+		return
+	}
+	defer procWTSUnRegisterSessionNotification.Call(hwnd)
+
+	// The message loop only wakes up for messages, so cancelling has to
+	// send it one
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			procPostThreadMessageW.Call(uintptr(threadID), WM_QUIT, 0, 0)
+		case <-done:
+		}
+	}()
+
+	var msg windowMessage
+	for {
+		result, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+
+		// 0 means WM_QUIT, -1 an error
+		switch int32(result) {
+		case 0:
+			return
+		case -1:
+			printError("Failed to read session messages:", err)
+			return
+		}
+
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+	}
+}
+
+// Registers the window class and creates the message-only window the session
+// notifications are sent to
+func createSessionWindow() (uintptr, error) {
+	var instance windows.Handle
+	err := windows.GetModuleHandleEx(0, nil, &instance)
+	if err != nil {
+		return 0, err
+	}
+
+	className, err := windows.UTF16PtrFromString(SESSION_WINDOW_CLASS)
+	if err != nil {
+		return 0, err
+	}
+
+	class := wndClassEx{
+		wndProc:   windows.NewCallback(sessionWindowProc),
+		instance:  instance,
+		className: className,
+	}
+	class.size = uint32(unsafe.Sizeof(class))
+
+	atom, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&class)))
+	if atom == 0 {
+		return 0, err
+	}
+
+	hwnd, _, err := procCreateWindowExW.Call(
+		0,
+		uintptr(unsafe.Pointer(className)),
+		0,
+		0,
+		0, 0, 0, 0,
+		HWND_MESSAGE,
+		0,
+		uintptr(instance),
+		0,
+	)
+	if hwnd == 0 {
+		if err == nil {
+			err = errors.New("CreateWindowExW failed")
+		}
+		return 0, err
+	}
+
+	return hwnd, nil
+}
+
+// Handles the messages sent to the session window
+func sessionWindowProc(hwnd uintptr, message uintptr, wParam uintptr, lParam uintptr) uintptr {
+	if message == WM_WTSSESSION_CHANGE {
+		switch wParam {
+		case WTS_SESSION_LOCK:
+			controller.SessionLocked()
+		case WTS_SESSION_UNLOCK:
+			controller.SessionUnlocked()
+		}
+		return 0
+	}
+
+	result, _, _ := procDefWindowProcW.Call(hwnd, message, wParam, lParam)
+	return result
+}
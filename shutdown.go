@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How long shutting down waits for the goroutines to stop, before cleaning
+// up anyway. A goroutine stuck in a system call shouldn't keep the monitor
+// from exiting
+const SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// Cancelled when the monitor starts shutting down. Every long running
+// goroutine is given this context, and returns once it's done
+var rootContext, cancelRootContext = context.WithCancel(context.Background())
+
+// Goroutines started with startWorker, which shutting down waits for. The
+// mutex makes sure none are added once the waiting has started
+var workers sync.WaitGroup
+var workersMutex sync.Mutex
+
+// Runs the function in a goroutine that shutting down waits for, so it gets
+// to clean up after itself before the shutdown hooks run
+func startWorker(fn func()) {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+
+	// Too late to be waited for, but it still gets to run
+	if rootContext.Err() != nil {
+		go fn()
+		return
+	}
+
+	workers.Add(1)
+
+	go func() {
+		defer workers.Done()
+		fn()
+	}()
+}
+
+// Waits for the duration, returning false right away if the context is
+// cancelled in the meantime
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Cleanup functions run by shutdown(), in the reverse order they were
+// registered in
+var shutdownHooks []func()
+var shutdownMutex sync.Mutex
+
+// Registers a function that releases a resource when the program shuts down,
+// like the pipe listener or the lock file
+func onShutdown(hook func()) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// Makes sure the cleanup functions only run once
+var shutdownOnce sync.Once
+
+// Cancels the root context, waits for the workers to stop and runs every
+// registered cleanup function, without exiting. The workers go first, so
+// the log file is only closed once nothing writes to it anymore. If several
+// goroutines try to shut down at once, the cleanup only runs once and the
+// others wait for it to finish
+func runShutdownHooks() {
+	shutdownOnce.Do(func() {
+		workersMutex.Lock()
+		cancelRootContext()
+		workersMutex.Unlock()
+
+		waitForWorkers(SHUTDOWN_TIMEOUT)
+
+		shutdownMutex.Lock()
+		defer shutdownMutex.Unlock()
+
+		for i := len(shutdownHooks) - 1; i >= 0; i-- {
+			shutdownHooks[i]()
+		}
+	})
+}
+
+// Waits for every worker to return, or for the timeout to pass
+func waitForWorkers(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		printWarning("Some goroutines didn't stop in time, shutting down anyway")
+	}
+}
+
+// Runs every registered cleanup function and exits the program. A service
+// returns instead, and leaves the shutdown to the service handler, which
+// reports the stop to the SCM. Exiting would look like a crash to it
+func shutdown() {
+	if runningAsService {
+		requestServiceStop()
+		return
+	}
+
+	runShutdownHooks()
+	os.Exit(0)
+}
+
+// Runs the regular shutdown when Ctrl+C is pressed or the console window is
+// closed. Go installs a SetConsoleCtrlHandler handler that turns Ctrl+C and
+// Ctrl+Break into os.Interrupt, and closing the console, logging off or
+// shutting down into SIGTERM. For the latter, Windows waits for the handler
+// to return, which gives the cleanup a few seconds to finish
+func handleInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		received := <-signals
+		printInfof("Received %v, shutting down\n", received)
+		shutdown()
+	}()
+}
+
+// Reloads the config on SIGHUP, like the -reload command, which also reopens
+// the log file for external log rotators. Windows never sends SIGHUP, so
+// there it's only -reload
+func handleHangups() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			printInfo("Received SIGHUP, reloading the config")
+			controller.Reload()
+		}
+	}()
+}
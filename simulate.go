@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// How long each simulated proxy setting lasts before the next one
+const SIMULATE_STEP = 8 * time.Second
+
+// Prefix of every log line written while simulating, so made up changes
+// can't be mistaken for real ones in the log
+const SIMULATED_PREFIX = "[simulated] "
+
+// A simulated state of the proxy settings
+type simulatedProxy struct {
+	proxyEnable uint64
+	proxyServer string
+}
+
+// Proxy settings the simulation goes through, over and over. Covers every
+// kind of change: turning the proxy on, swapping the server while it's on,
+// per-protocol proxies and turning it off
+var SIMULATED_PROXIES = []simulatedProxy{
+	{proxyEnable: 1, proxyServer: "proxy.example.com:8080"},
+	{proxyEnable: 1, proxyServer: "10.0.0.5:3128"},
+	{proxyEnable: 0, proxyServer: "10.0.0.5:3128"},
+	{proxyEnable: 1, proxyServer: "http=proxy.example.com:80;https=proxy.example.com:443"},
+	{proxyEnable: 0, proxyServer: "http=proxy.example.com:80;https=proxy.example.com:443"},
+}
+
+// Set when the main instance was started with -simulate. The registry is
+// never read, every watcher gets made up settings instead
+var simulateOption bool
+
+// Makes up proxy settings that flip on a timer, for demos, screenshots and
+// trying out the tray and notifications without touching the registry. The
+// settings only depend on how long ago the reader was created
+type simulatedProxyReader struct {
+	mutex     sync.Mutex
+	startedAt time.Time
+}
+
+// Creates a reader that starts out with the proxy off
+func newSimulatedProxyReader() *simulatedProxyReader {
+	return &simulatedProxyReader{startedAt: time.Now()}
+}
+
+// The simulated settings right now. The first step has the proxy off, so the
+// first change shows up a step after starting
+func (r *simulatedProxyReader) current() simulatedProxy {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	step := int(time.Since(r.startedAt) / SIMULATE_STEP)
+	if step == 0 {
+		return simulatedProxy{}
+	}
+
+	return SIMULATED_PROXIES[(step-1)%len(SIMULATED_PROXIES)]
+}
+
+func (r *simulatedProxyReader) ProxyEnable() (uint64, error) {
+	return r.current().proxyEnable, nil
+}
+
+func (r *simulatedProxyReader) ProxyServer() (string, error) {
+	return r.current().proxyServer, nil
+}
+
+func (r *simulatedProxyReader) Source() string {
+	return ""
+}
+
+func (r *simulatedProxyReader) Reopen() error {
+	return nil
+}
+
+func (r *simulatedProxyReader) Close() error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+// Counts of the proxy changes seen by a watcher, or all of them together
+type changeStats struct {
+	// Every logged change, including ones that only changed the server while
+	// the proxy was off
+	Changes int `json:"changes"`
+
+	// The proxy being turned on and off
+	Enabled  int `json:"enabled"`
+	Disabled int `json:"disabled"`
+
+	// The server changing while the proxy stayed on
+	ServerChanges int `json:"server_changes"`
+}
+
+// Counts a change from the previous settings to the new ones. Reading the
+// baseline isn't a real change, so it's up to the watcher not to count it
+func (s *changeStats) count(previousEnable uint64, previousServer string, proxyEnable uint64, proxyServer string) {
+	s.Changes++
+
+	wasOn := previousEnable != 0
+	isOn := proxyEnable != 0
+
+	switch {
+	case !wasOn && isOn:
+		s.Enabled++
+	case wasOn && !isOn:
+		s.Disabled++
+	case wasOn && isOn && previousServer != proxyServer:
+		s.ServerChanges++
+	}
+}
+
+// Adds another watcher's counts to these
+func (s *changeStats) add(other changeStats) {
+	s.Changes += other.Changes
+	s.Enabled += other.Enabled
+	s.Disabled += other.Disabled
+	s.ServerChanges += other.ServerChanges
+}
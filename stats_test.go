@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestWatcherStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []scriptedRead
+		want   changeStats
+	}{
+		{
+			name:   "proxy on at startup",
+			script: []scriptedRead{{proxyEnable: 1, proxyServer: "10.0.0.1:8080"}},
+			want:   changeStats{},
+		},
+		{
+			name:   "proxy off at startup",
+			script: []scriptedRead{{proxyEnable: 0}},
+			want:   changeStats{},
+		},
+		{
+			name: "turned on after startup",
+			script: []scriptedRead{
+				{proxyEnable: 0},
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+			},
+			want: changeStats{Changes: 1, Enabled: 1},
+		},
+		{
+			name: "every kind of change",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnable: 1, proxyServer: "10.0.0.2:8080"},
+				{proxyEnable: 0, proxyServer: "10.0.0.2:8080"},
+				{proxyEnable: 0, proxyServer: "10.0.0.3:8080"},
+				{proxyEnable: 1, proxyServer: "10.0.0.3:8080"},
+			},
+			want: changeStats{Changes: 4, Enabled: 1, Disabled: 1, ServerChanges: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, state := runScriptedWatcher(t, testWatcherConfig(t), test.script)
+
+			state.mutex.Lock()
+			got := state.stats
+			state.mutex.Unlock()
+
+			if got != test.want {
+				t.Errorf("stats = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWatcherStatsAfterReset(t *testing.T) {
+	state := &watchState{}
+	state.update(1, "10.0.0.1:8080")
+	state.startCounting()
+
+	state.update(0, "10.0.0.1:8080")
+
+	// The baseline after a reset isn't a change either
+	state.reset()
+	state.update(1, "10.0.0.1:8080")
+	state.startCounting()
+
+	state.update(0, "10.0.0.1:8080")
+
+	want := changeStats{Changes: 2, Disabled: 2}
+	if state.stats != want {
+		t.Errorf("stats = %+v, want %+v", state.stats, want)
+	}
+}
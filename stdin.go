@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Commands that can be sent on stdin with -stdin, one per line
+var STDIN_COMMANDS = map[string]byte{
+	"start":    CMD_START,
+	"stop":     CMD_STOP,
+	"restart":  CMD_RESTART,
+	"reload":   CMD_RELOAD,
+	"clearlog": CMD_CLEARLOG,
+	"status":   CMD_STATUS,
+	"quit":     CMD_QUIT,
+}
+
+// Reads commands from stdin, for a parent process that keeps the monitor as
+// a child instead of running -start and -stop. Every command is answered
+// with a line of JSON on stdout, the same as the HTTP server's responses.
+// Nothing else is written to stdout, the console output goes to stderr.
+// Stdin being closed only stops the reading, monitoring keeps going until
+// quit is sent some other way
+func listenToStdin() {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		cmd, ok := STDIN_COMMANDS[line]
+		if !ok {
+			writeStdinResponse(commandResult{OK: false, Error: "unknown_command"})
+			continue
+		}
+
+		switch cmd {
+		case CMD_STATUS:
+			writeStdinResponse(controller.Status())
+
+		case CMD_QUIT:
+			// Exits the process, so the response has to go out first
+			writeStdinResponse(commandResult{OK: true})
+			executeCommand(cmd)
+
+		default:
+			code := executeCommand(cmd)
+			writeStdinResponse(commandResult{OK: code == RESPONSE_OK, Error: responseCodeName(code)})
+		}
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		printError("Failed to read commands from stdin:", err)
+		return
+	}
+
+	printInfo("Stdin was closed, monitoring continues")
+}
+
+// Writes a response to a stdin command as a single line of JSON
+func writeStdinResponse(value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		printError("Failed to encode stdin response:", err)
+		return
+	}
+
+	fmt.Println(string(data))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Port syslog_addr uses when it doesn't have one
+const DEFAULT_SYSLOG_PORT = "514"
+
+// Facility every message is sent with, user-level messages
+const SYSLOG_FACILITY = 1
+
+// Syslog severities used for proxy changes: an unexpected proxy server is a
+// warning, turning the proxy on or off a notice, anything else informational
+const SYSLOG_SEVERITY_WARNING = 4
+const SYSLOG_SEVERITY_NOTICE = 5
+const SYSLOG_SEVERITY_INFO = 6
+
+// APP-NAME in every message
+const SYSLOG_APP_NAME = "proxy-monitor"
+
+// ID of the structured data element the change details are sent in. 32473 is
+// the enterprise number RFC 5424 reserves for examples and private use
+const SYSLOG_SD_ID = "proxy@32473"
+
+// How long connecting to or writing to the syslog server can take
+const SYSLOG_TIMEOUT = 5 * time.Second
+
+// How many messages can wait to be sent. When the server is slow and the
+// queue fills up, further messages are dropped instead of holding up the
+// watcher
+const SYSLOG_QUEUE_SIZE = 100
+
+// A message waiting to be sent, with where it goes
+type syslogMessage struct {
+	addr    string
+	line    string
+	prefix  string
+	logFile *monitorLog
+}
+
+var syslogQueue = make(chan syslogMessage, SYSLOG_QUEUE_SIZE)
+var syslogSenderOnce sync.Once
+
+// Connection to the syslog server, kept open between messages and redialed
+// when it breaks or syslog_addr changes
+var syslogConn net.Conn
+var syslogConnAddr string
+
+// Checks that syslog_addr is udp:// or tcp:// with a host, and returns it
+// with the default port added if it didn't have one
+func validateSyslogAddr(rawAddr string) (string, error) {
+	parsed, err := url.Parse(rawAddr)
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Scheme != "udp" && parsed.Scheme != "tcp" {
+		return "", fmt.Errorf("unsupported scheme: %s", parsed.Scheme)
+	}
+
+	if parsed.Hostname() == "" {
+		return "", fmt.Errorf("missing host")
+	}
+
+	if parsed.Port() == "" {
+		parsed.Host = net.JoinHostPort(parsed.Hostname(), DEFAULT_SYSLOG_PORT)
+	}
+
+	return parsed.Scheme + "://" + parsed.Host, nil
+}
+
+// Picks the syslog severity of a kind of proxy change
+func syslogSeverity(kind string, unexpected bool) int {
+	if unexpected {
+		return SYSLOG_SEVERITY_WARNING
+	}
+
+	if eventSeverity(kind) == SEVERITY_NOTABLE {
+		return SYSLOG_SEVERITY_NOTICE
+	}
+
+	return SYSLOG_SEVERITY_INFO
+}
+
+// Escapes the characters RFC 5424 doesn't allow as is in a PARAM-VALUE
+func escapeSyslogParam(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	return replacer.Replace(value)
+}
+
+// Formats an RFC 5424 message for a proxy change, with the kind of change as
+// the MSGID. The details go in structured data, so collectors can pick them
+// out without parsing the text
+func formatSyslogMessage(now time.Time, kind string, hive string, proxyOn bool, proxyServer string, unexpected []string, message string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	enabled := "0"
+	if proxyOn {
+		enabled = "1"
+	}
+
+	data := fmt.Sprintf(`[%s hive="%s" enabled="%s" server="%s"`,
+		SYSLOG_SD_ID, escapeSyslogParam(hive), enabled, escapeSyslogParam(normalizeProxyServer(proxyServer)))
+	if len(unexpected) > 0 {
+		data += fmt.Sprintf(` unexpected="%s"`, escapeSyslogParam(strings.Join(unexpected, ", ")))
+	}
+	data += "]"
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		SYSLOG_FACILITY*8+syslogSeverity(kind, len(unexpected) > 0),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		hostname,
+		SYSLOG_APP_NAME,
+		os.Getpid(),
+		kind,
+		data,
+		message)
+}
+
+// Queues a proxy change to be sent to the syslog server. Never blocks, if the
+// queue is full the message is dropped and that's logged instead
+func sendSyslog(addr string, kind string, hive string, proxyOn bool, proxyServer string, unexpected []string, message string, prefix string, logFile *monitorLog) {
+	line := formatSyslogMessage(time.Now(), kind, hive, proxyOn, proxyServer, unexpected, message)
+
+	syslogSenderOnce.Do(func() {
+		go runSyslogSender()
+	})
+
+	select {
+	case syslogQueue <- syslogMessage{addr: addr, line: line, prefix: prefix, logFile: logFile}:
+	default:
+		printWarning(prefix + "Syslog queue is full, dropping message")
+		writeLogEntry(logFile, prefix+"syslog message dropped, queue full")
+	}
+}
+
+// Sends queued messages one at a time, so they arrive in order over TCP.
+// Failures are only logged, a broken syslog server never stops the monitor
+func runSyslogSender() {
+	for message := range syslogQueue {
+		err := writeSyslog(message.addr, message.line)
+		if err != nil {
+			printError(message.prefix+"Failed to send syslog message:", err)
+			writeLogEntry(message.logFile, message.prefix+"syslog failed: "+err.Error())
+		}
+	}
+}
+
+// Writes a single message, dialing first if there's no connection yet. A
+// TCP connection the server closed in the meantime is only noticed when
+// writing, so a failed write is retried once on a new connection
+func writeSyslog(addr string, line string) error {
+	if syslogConn != nil && syslogConnAddr != addr {
+		closeSyslog()
+	}
+
+	err := writeSyslogOnce(addr, line)
+	if err != nil {
+		closeSyslog()
+		err = writeSyslogOnce(addr, line)
+	}
+
+	return err
+}
+
+// Dials if needed and writes the message, without retrying
+func writeSyslogOnce(addr string, line string) error {
+	if syslogConn == nil {
+		network, address, _ := strings.Cut(addr, "://")
+
+		conn, err := net.DialTimeout(network, address, SYSLOG_TIMEOUT)
+		if err != nil {
+			return err
+		}
+
+		syslogConn = conn
+		syslogConnAddr = addr
+	}
+
+	// TCP needs framing to tell messages apart, RFC 6587 octet counting
+	frame := line
+	if strings.HasPrefix(addr, "tcp://") {
+		frame = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	syslogConn.SetWriteDeadline(time.Now().Add(SYSLOG_TIMEOUT))
+	_, err := syslogConn.Write([]byte(frame))
+	return err
+}
+
+// Drops the connection, so the next message dials again
+func closeSyslog() {
+	if syslogConn != nil {
+		syslogConn.Close()
+		syslogConn = nil
+	}
+}
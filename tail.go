@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long a write to a -tail client may take before the client is dropped,
+// so a stuck client can't hold up the monitor
+const TAIL_WRITE_TIMEOUT = 1 * time.Second
+
+// Connections of clients that are streaming log lines with -tail
+var tailSubscribers []net.Conn
+var tailMutex sync.Mutex
+
+// Registers a client connection to receive every new log line. The
+// connection stays open until the client disconnects or a write fails
+func addTailSubscriber(conn net.Conn) {
+	tailMutex.Lock()
+	tailSubscribers = append(tailSubscribers, conn)
+	tailMutex.Unlock()
+
+	// Clients never send anything after the tail command, so a read only
+	// returns when the client disconnects
+	go func() {
+		io.Copy(io.Discard, conn)
+		removeTailSubscriber(conn)
+	}()
+}
+
+// Removes a client connection from the subscribers and closes it
+func removeTailSubscriber(conn net.Conn) {
+	tailMutex.Lock()
+	defer tailMutex.Unlock()
+
+	for i, subscriber := range tailSubscribers {
+		if subscriber == conn {
+			tailSubscribers = append(tailSubscribers[:i], tailSubscribers[i+1:]...)
+			break
+		}
+	}
+
+	conn.Close()
+}
+
+// Closes every -tail client's connection, which ends their -tail command
+func closeTailSubscribers() {
+	tailMutex.Lock()
+	defer tailMutex.Unlock()
+
+	for _, conn := range tailSubscribers {
+		conn.Close()
+	}
+
+	tailSubscribers = nil
+}
+
+// Sends a log line to every -tail client, dropping the ones that can't be
+// written to anymore
+func publishTailLine(line string) {
+	tailMutex.Lock()
+	defer tailMutex.Unlock()
+
+	alive := tailSubscribers[:0]
+
+	for _, conn := range tailSubscribers {
+		conn.SetWriteDeadline(time.Now().Add(TAIL_WRITE_TIMEOUT))
+
+		err := writeFrame(conn, []byte(line))
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		alive = append(alive, conn)
+	}
+
+	tailSubscribers = alive
+}
+
+// Prints log lines streamed by the main program instance until the
+// connection is closed
+func printTail(conn net.Conn) {
+	fmt.Println("Streaming proxy changes, press Ctrl+C to stop.")
+
+	for {
+		line, err := readFrame(conn)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Println("Lost connection to main program instance:", err)
+			}
+			return
+		}
+
+		fmt.Println(string(line))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+// Proxy settings as last seen by the monitor
+type proxyState struct {
+	enabled bool
+	server  string
+}
+
+// Channels used to push state changes to the system tray. The tray goroutine
+// keeps its own copy of the state, so it never reads variables owned by the
+// monitor or pipe goroutines. Both only ever hold the latest value
+var trayMonitoringCh = make(chan bool, 1)
+var trayProxyCh = make(chan proxyState, 1)
+
+// Signals that a change was added to the history, which the tray reads
+// itself. Carries no value, so any number of changes comes down to one
+// refresh
+var trayHistoryCh = make(chan struct{}, 1)
+
+// How many of the most recent changes the tray's submenu shows
+const TRAY_RECENT_CHANGES = 8
+
+// Lets the tray know that monitoring has been started or stopped
+func notifyTrayMonitoring(monitoring bool) {
+	sendLatest(trayMonitoringCh, monitoring)
+}
+
+// Lets the tray know that the proxy settings have changed
+func notifyTrayProxy(state proxyState) {
+	sendLatest(trayProxyCh, state)
+}
+
+// Lets the tray know that a change was added to the history
+func notifyTrayHistory() {
+	sendLatest(trayHistoryCh, struct{}{})
+}
+
+// Sends a value to a channel with a buffer of 1 without blocking. If the
+// previous value hasn't been received yet, it's replaced, since only the
+// latest state matters to the tray
+func sendLatest[T any](ch chan T, value T) {
+	for {
+		select {
+		case ch <- value:
+			return
+		default:
+		}
+
+		// Drop the stale value and try again
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
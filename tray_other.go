@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "context"
+
+// The tray library needs GTK outside of Windows, which isn't worth it for
+// development builds, so there's no tray icon. State changes sent to the tray
+// channels never get read, which is fine since they only keep the latest one
+func createSystemTrayIcon(ctx context.Context) {}
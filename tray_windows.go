@@ -0,0 +1,280 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"golang.org/x/sys/windows"
+
+	// System tray library and their example icon
+	"github.com/getlantern/systray"
+	"github.com/getlantern/systray/example/icon"
+)
+
+// Tray icon shown when monitoring and the proxy is turned on
+//
+//go:embed icons/proxy_on.ico
+var iconProxyOn []byte
+
+// Tray icon shown when monitoring has been stopped
+//
+//go:embed icons/paused.ico
+var iconPaused []byte
+
+// How long the tray icon gets to show up before the monitor carries on
+// without it
+const TRAY_READY_TIMEOUT = 10 * time.Second
+
+// Message that ends a thread's message loop
+const WM_QUIT = 0x0012
+
+var procPostThreadMessageW = user32.NewProc("PostThreadMessageW")
+
+// Runs the tray icon until it exits or the context is cancelled, which
+// removes the icon. Without an interactive desktop, the tray library fails to
+// create its window and then waits for messages forever, so if it isn't ready
+// in time, its message loop is told to quit. Whatever happens to the tray,
+// monitoring keeps going without it
+func createSystemTrayIcon(ctx context.Context) {
+	// The tray's window and message loop belong to this thread, which is
+	// where the quit message has to be sent
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	threadID := windows.GetCurrentThreadId()
+
+	defer recoverTray()
+
+	controller.Subscribe(notifyTrayMonitoring)
+
+	ready := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ready:
+		case <-done:
+			return
+		case <-ctx.Done():
+			procPostThreadMessageW.Call(uintptr(threadID), WM_QUIT, 0, 0)
+			return
+		case <-time.After(TRAY_READY_TIMEOUT):
+			printWarning("Tray icon couldn't be created, monitoring continues without it")
+			procPostThreadMessageW.Call(uintptr(threadID), WM_QUIT, 0, 0)
+			return
+		}
+
+		// Not done from the menu's goroutine, which is the one shutting
+		// down when Quit is clicked
+		select {
+		case <-ctx.Done():
+			systray.Quit()
+		case <-done:
+		}
+	}()
+
+	systray.Run(
+		func() {
+			defer recoverTray()
+			close(ready)
+
+			monitoring := controller.Enabled()
+			proxy := proxyState{}
+
+			updateTrayIcon(monitoring, proxy.enabled)
+			updateTrayTooltip(monitoring, proxy)
+			systray.SetTitle("Proxy Monitor")
+
+			// Not clickable, only shows the current proxy
+			proxyLabel := systray.AddMenuItem(trayProxyLabel(proxy), "Current proxy settings")
+			proxyLabel.Disable()
+
+			// Every item is created up front and only shown once there's a
+			// change for it, since items can't be removed again
+			recent := systray.AddMenuItem("Recent changes", "The most recent proxy changes")
+			recentItems := make([]*systray.MenuItem, TRAY_RECENT_CHANGES)
+			for i := range recentItems {
+				recentItems[i] = recent.AddSubMenuItem("", "")
+				recentItems[i].Disable()
+			}
+			updateTrayRecentChanges(recentItems)
+			systray.AddSeparator()
+
+			start := systray.AddMenuItem("Start", "Start monitoring")
+			stop := systray.AddMenuItem("Stop", "Stop monitoring")
+			systray.AddSeparator()
+			openLog := systray.AddMenuItem("Open Log", "Open the log file")
+			openLogFolder := systray.AddMenuItem("Open Log Folder", "Open the folder the log file is in")
+			systray.AddSeparator()
+			quit := systray.AddMenuItem("Quit", "Quit monitoring")
+
+			updateTrayMenu(monitoring, start, stop)
+
+			go func() {
+				defer recoverTray()
+
+				for {
+					select {
+					case <-done:
+						return
+
+					case <-start.ClickedCh:
+						executeCommand(CMD_START)
+
+					case <-stop.ClickedCh:
+						executeCommand(CMD_STOP)
+
+					case <-openLog.ClickedCh:
+						openInShell(currentLogPath())
+
+					case <-openLogFolder.ClickedCh:
+						openInShell(filepath.Dir(currentLogPath()))
+
+					case <-quit.ClickedCh:
+						controller.Quit()
+
+					case monitoring = <-trayMonitoringCh:
+						updateTrayMenu(monitoring, start, stop)
+						updateTrayIcon(monitoring, proxy.enabled)
+						updateTrayTooltip(monitoring, proxy)
+
+					case <-trayHistoryCh:
+						updateTrayRecentChanges(recentItems)
+
+					case proxy = <-trayProxyCh:
+						proxyLabel.SetTitle(trayProxyLabel(proxy))
+						updateTrayIcon(monitoring, proxy.enabled)
+						updateTrayTooltip(monitoring, proxy)
+					}
+				}
+			}()
+		},
+		nil)
+
+	close(done)
+
+	// Only quit by the monitor when it's shutting down
+	if ctx.Err() != nil {
+		return
+	}
+
+	select {
+	case <-ready:
+		printWarning("Tray icon exited, monitoring continues without it")
+	default:
+	}
+}
+
+// Keeps a panic in the tray from taking down the monitor, must be deferred
+func recoverTray() {
+	r := recover()
+	if r != nil {
+		printWarning("Tray icon crashed, monitoring continues without it:", r)
+	}
+}
+
+// Opens a file in its default program, or a folder in Explorer
+func openInShell(path string) {
+	file, err := windows.UTF16PtrFromString(path)
+	if err == nil {
+		err = windows.ShellExecute(0, windows.StringToUTF16Ptr("open"), file, nil, nil, windows.SW_SHOWNORMAL)
+	}
+
+	if err != nil {
+		printErrorf("Failed to open %s: %v\n", path, err)
+	}
+}
+
+// Only lets the user click the menu item that would actually change the
+// monitoring state, and puts a check mark on the current one
+func updateTrayMenu(monitoring bool, start, stop *systray.MenuItem) {
+	if monitoring {
+		start.Disable()
+		start.Check()
+		stop.Enable()
+		stop.Uncheck()
+	} else {
+		start.Enable()
+		start.Uncheck()
+		stop.Disable()
+		stop.Check()
+	}
+}
+
+// Fills the recent changes submenu from the history, newest first, and hides
+// the items there are no changes for. Must be called on the tray goroutine
+func updateTrayRecentChanges(items []*systray.MenuItem) {
+	entries := proxyHistory.list()
+
+	// Only tell the hives apart when there's more than one
+	hives, _ := parseRegistryHives(currentConfig().RegistryHive)
+	showHive := len(hives) > 1
+
+	for i, item := range items {
+		if i >= len(entries) {
+			item.Hide()
+			continue
+		}
+
+		item.SetTitle(trayRecentChangeLabel(entries[len(entries)-1-i], showHive))
+		item.Show()
+	}
+
+	if len(entries) == 0 {
+		items[0].SetTitle("No changes yet")
+		items[0].Show()
+	}
+}
+
+// Text of a recent change's menu item, like "14:03 ON 10.0.0.1:8080"
+func trayRecentChangeLabel(entry historyEntry, showHive bool) string {
+	label := entry.Time.Format("15:04")
+	if showHive {
+		label += " " + entry.Hive
+	}
+
+	if !entry.Enabled {
+		return label + " OFF"
+	}
+
+	return label + " ON " + displayProxyServer(entry.Server)
+}
+
+// Changes the tray icon to match the monitor's state: grey when monitoring is
+// stopped, green when the proxy is on and the default icon when it's off
+func updateTrayIcon(monitoring, proxyOn bool) {
+	switch {
+	case !monitoring:
+		systray.SetIcon(iconPaused)
+	case proxyOn:
+		systray.SetIcon(iconProxyOn)
+	default:
+		systray.SetIcon(icon.Data)
+	}
+}
+
+// Text of the menu item showing the current proxy
+func trayProxyLabel(proxy proxyState) string {
+	if !proxy.enabled {
+		return "Proxy: off"
+	}
+
+	return "Proxy: " + displayProxyServer(proxy.server)
+}
+
+// Shows the current state when hovering over the tray icon
+func updateTrayTooltip(monitoring bool, proxy proxyState) {
+	switch {
+	case !monitoring:
+		systray.SetTooltip("Monitoring paused")
+	case proxy.enabled:
+		servers := displayProxyServer(proxy.server)
+		systray.SetTooltip("Proxy ON — " + servers)
+	default:
+		systray.SetTooltip("Proxy OFF")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// There are no Windows accounts to look SIDs up in outside of Windows
+func lookupAccountName(sid string) (string, error) {
+	return "", errors.New("account lookup is only available on Windows")
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/sys/windows"
+
+// Looks up the account a SID belongs to, as DOMAIN\user
+func lookupAccountName(sid string) (string, error) {
+	parsed, err := windows.StringToSid(sid)
+	if err != nil {
+		return "", err
+	}
+
+	account, domain, _, err := parsed.LookupAccount("")
+	if err != nil {
+		return "", err
+	}
+
+	if domain == "" {
+		return account, nil
+	}
+
+	return domain + `\` + account, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, injected at build time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=abc123 -X main.buildDate=2024-06-01"
+var version = "dev"
+var commit = ""
+var buildDate = ""
+
+// Returns the build version, commit, date and Go version as a single line.
+// When the commit or date weren't injected, they're taken from the VCS
+// information Go embeds in the binary, if there is any
+func versionString() string {
+	revision := commit
+	date := buildDate
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if revision == "" {
+					revision = setting.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = setting.Value
+				}
+			}
+		}
+	}
+
+	if revision == "" {
+		revision = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	return fmt.Sprintf("proxy-monitor %s (commit %s, built %s, %s)", version, revision, date, runtime.Version())
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Shown in place of a watched value's contents when the key or value doesn't
+// exist
+const VALUE_NOT_SET = "(not set)"
+
+// Types of registry values that can be watched
+const VALUE_TYPE_STRING = "string"
+const VALUE_TYPE_DWORD = "dword"
+const VALUE_TYPE_QWORD = "qword"
+const VALUE_TYPE_BINARY = "binary"
+const VALUE_TYPE_MULTI_STRING = "multi_string"
+
+// An additional registry value to watch for changes, as set in the config
+type WatchedValue struct {
+	// HKCU or HKLM
+	Hive string `json:"hive"`
+
+	// Path of the key the value is in, relative to the hive
+	KeyPath string `json:"key_path"`
+
+	// Name of the value, empty for the key's default value
+	ValueName string `json:"value_name"`
+
+	// One of string, dword, qword, binary or multi_string
+	Type string `json:"type"`
+}
+
+// Full name of the value, used in log lines
+func (v WatchedValue) String() string {
+	return strings.ToUpper(v.Hive) + `\` + v.KeyPath + `\` + v.ValueName
+}
+
+// Checks that the watched value's settings are valid
+func (v WatchedValue) validate() error {
+	hives, err := parseRegistryHives(v.Hive)
+	if err != nil {
+		return err
+	}
+	if len(hives) != 1 {
+		return fmt.Errorf("a watched value can only be in one hive, got %s", v.Hive)
+	}
+
+	if v.KeyPath == "" {
+		return errors.New("key_path is not set")
+	}
+
+	switch v.Type {
+	case VALUE_TYPE_STRING, VALUE_TYPE_DWORD, VALUE_TYPE_QWORD, VALUE_TYPE_BINARY, VALUE_TYPE_MULTI_STRING:
+		return nil
+	default:
+		return fmt.Errorf("unknown value type: %s", v.Type)
+	}
+}
+
+// Reads the value from the registry and formats it as a string, so values of
+// every type can be compared and logged the same way
+func (v WatchedValue) read() (string, error) {
+	// Validated when the config was loaded
+	hives, _ := parseRegistryHives(v.Hive)
+
+	key, err := proxymon.OpenKey(hives[0], v.KeyPath)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return VALUE_NOT_SET, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+
+	var value string
+
+	switch v.Type {
+	case VALUE_TYPE_STRING:
+		value, _, err = key.GetStringValue(v.ValueName)
+
+	case VALUE_TYPE_DWORD, VALUE_TYPE_QWORD:
+		var number uint64
+		number, _, err = key.GetIntegerValue(v.ValueName)
+		value = strconv.FormatUint(number, 10)
+
+	case VALUE_TYPE_BINARY:
+		var data []byte
+		data, _, err = key.GetBinaryValue(v.ValueName)
+		value = hex.EncodeToString(data)
+
+	case VALUE_TYPE_MULTI_STRING:
+		var values []string
+		values, _, err = key.GetStringsValue(v.ValueName)
+		value = strings.Join(values, ", ")
+	}
+
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return VALUE_NOT_SET, nil
+	}
+
+	return value, err
+}
+
+// Context values that couldn't be read, keyed by their full names, so a
+// missing permission isn't reported again with every change
+var contextValueFailures failureReporter
+
+// Reads the context values from the config, keyed by their value names.
+// Values that can't be read are left out, they're only extra information
+func readContextValues(values []WatchedValue) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	contextValues := map[string]string{}
+	for _, watched := range values {
+		value, err := watched.read()
+		if err != nil {
+			if contextValueFailures.shouldReport(watched.String(), err) {
+				printErrorf("Failed to read context value %s: %v\n", watched, err)
+			}
+			continue
+		}
+
+		contextValueFailures.clear(watched.String())
+		contextValues[watched.displayName()] = value
+	}
+
+	return contextValues
+}
+
+// Formats the context values for a log line, in the order they're listed in
+// the config, like "ProxyHttp1.1=1, SecureProtocols=2688"
+func formatContextValues(values []WatchedValue, contextValues map[string]string) string {
+	parts := []string{}
+	for _, watched := range values {
+		value, ok := contextValues[watched.displayName()]
+		if ok {
+			parts = append(parts, watched.displayName()+"="+value)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Short name of the value, without the key it's in
+func (v WatchedValue) displayName() string {
+	if v.ValueName == "" {
+		return "(default)"
+	}
+
+	return v.ValueName
+}
+
+// Checks the watched values from the config for changes in a loop. The first
+// read of each value is its baseline, after that every change is logged
+func watchConfiguredValues(ctx context.Context, logFile *monitorLog, config Config) {
+	values := config.WatchedValues
+	lastValues := make([]string, len(values))
+	hasBaseline := make([]bool, len(values))
+
+	// Keyed by the full names of the values
+	var failures failureReporter
+
+	for {
+		// The list of values is fixed, but the poll interval can be changed
+		// by reloading the config
+		config = currentConfig()
+
+		if controller.Enabled() {
+			for i, watched := range values {
+				value, err := watched.read()
+				if err != nil {
+					if failures.shouldReport(watched.String(), err) {
+						printErrorf("Failed to read watched value %s: %v\n", watched, err)
+					}
+					continue
+				}
+
+				failures.clear(watched.String())
+
+				if hasBaseline[i] && value != lastValues[i] {
+					writeLogEntry(logFile, fmt.Sprintf("watched value %s changed: %s -> %s", watched, lastValues[i], value))
+				}
+
+				lastValues[i] = value
+				hasBaseline[i] = true
+			}
+		}
+
+		if !sleepContext(ctx, config.pollInterval()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,749 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Sentinel ProxyEnable value that never matches a real registry value, used
+// to force the next check to log the current state
+const UNKNOWN_PROXY_ENABLE uint64 = math.MaxUint64
+
+// While debouncing, how often the registry is read to see if the settings
+// have settled
+const DEBOUNCE_POLL_INTERVAL = 50 * time.Millisecond
+
+// If the settings keep changing for this many debounce windows, they're
+// logged anyway, so a constantly flipping proxy is still noticed
+const DEBOUNCE_MAX_WINDOWS = 10
+
+// Added to the proxy change lines when Group Policy enforces the settings,
+// like "proxy on (policy-enforced): 10.0.0.1:8080"
+const POLICY_ENFORCED_NOTE = "(policy-enforced)"
+
+// Last known proxy settings of a watched registry key
+type watchState struct {
+	mutex       sync.Mutex
+	hive        string
+	proxyEnable uint64
+	proxyServer string
+
+	// Set when the watcher stopped because reading the registry failed
+	failed bool
+
+	// Polls the registry for this state, nil until it has started
+	watcher *proxymon.Watcher
+
+	// How many changes were seen since the monitor started
+	stats changeStats
+
+	// Whether changes are counted in the stats. Only set once the first
+	// check has read the baseline, which isn't a change
+	counting bool
+
+	// Settings loaded from the previous run, kept until the first check has
+	// compared them to the registry
+	previousRun *persistedHive
+}
+
+// States of every running watcher, so the restart command can reach them
+var watchStates []*watchState
+var watchStatesMutex sync.Mutex
+
+// Creates the state for a new watcher and registers it
+func newWatchState(hive string) *watchState {
+	state := &watchState{hive: hive}
+
+	watchStatesMutex.Lock()
+	defer watchStatesMutex.Unlock()
+
+	watchStates = append(watchStates, state)
+	return state
+}
+
+// Reports whether the settings differ from the last known ones
+func (s *watchState) differs(proxyEnable uint64, proxyServer string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return proxyEnable != s.proxyEnable || proxyServer != s.proxyServer
+}
+
+// Compares the settings to the last known ones and stores them as the new
+// last known settings. Returns true if they changed, along with the
+// previous ProxyEnable and ProxyServer values
+func (s *watchState) update(proxyEnable uint64, proxyServer string) (bool, uint64, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previousEnable := s.proxyEnable
+	previousServer := s.proxyServer
+
+	if proxyEnable == s.proxyEnable && proxyServer == s.proxyServer {
+		return false, previousEnable, previousServer
+	}
+
+	if s.counting {
+		s.stats.count(previousEnable, previousServer, proxyEnable, proxyServer)
+	}
+
+	s.proxyEnable = proxyEnable
+	s.proxyServer = proxyServer
+	return true, previousEnable, previousServer
+}
+
+// Starts from the settings saved by the previous run, so the first check
+// notices if they changed while the monitor wasn't running
+func (s *watchState) restore(saved persistedHive) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.proxyEnable = saved.ProxyEnable
+	s.proxyServer = saved.ProxyServer
+	s.previousRun = &saved
+}
+
+// Counts every change from now on, once the baseline has been read
+func (s *watchState) startCounting() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counting = true
+}
+
+// Returns the settings from the previous run, or nil if there weren't any,
+// and forgets them, since they're only needed for the first check
+func (s *watchState) takePreviousRun() *persistedHive {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previousRun := s.previousRun
+	s.previousRun = nil
+	return previousRun
+}
+
+// Forgets the last known settings, so the next check logs the current state
+// as a fresh baseline
+func (s *watchState) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.proxyEnable = UNKNOWN_PROXY_ENABLE
+	s.proxyServer = ""
+	s.counting = false
+
+	// Otherwise unchanged settings would never be sent to be compared
+	if s.watcher != nil {
+		s.watcher.Reset()
+	}
+}
+
+// Reports whether any of the watchers stopped because of a registry error
+func anyWatcherFailed() bool {
+	watchStatesMutex.Lock()
+	defer watchStatesMutex.Unlock()
+
+	for _, state := range watchStates {
+		state.mutex.Lock()
+		failed := state.failed
+		state.mutex.Unlock()
+
+		if failed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resets the baseline of every running watcher
+func resetWatchStates() {
+	watchStatesMutex.Lock()
+	defer watchStatesMutex.Unlock()
+
+	for _, state := range watchStates {
+		state.reset()
+	}
+}
+
+// Detects changes in a loop in the windows registry, running one watcher for
+// every hive in the config. Returns once every watcher has stopped, either
+// because the context was cancelled or because reading the registry failed
+func listenToProxyChanges(ctx context.Context, config Config) {
+	// The config has already been validated, so this can't fail
+	hives, _ := parseRegistryHives(config.RegistryHive)
+
+	logFile, err := openMonitorLog(config)
+	if err != nil {
+		printError("Failed to open log file:", err)
+		return
+	}
+
+	printInfo("Logging output to", logFile.name())
+
+	// Makes it possible to tell which process is the main instance, and when
+	// it was started, from the log alone
+	startMessage := fmt.Sprintf("proxy-monitor server started, pid=%d", os.Getpid())
+	printInfo(time.Now().Format(config.timestampLayout()), startMessage)
+	writeLogEntry(logFile, startMessage)
+
+	// The event log is just an extra place changes are written to, so the
+	// monitor keeps running with the file log if it can't be opened
+	if config.EventLog {
+		err = openEventLog()
+		if err != nil {
+			printWarning("Not writing to the event log:", err)
+		}
+	}
+
+	// Make sure everything that was logged ends up on disk before exiting
+	onShutdown(logFile.close)
+
+	// Right after boot, the network is still coming up and the settings can
+	// change a few times, which isn't worth logging. The baseline is only
+	// read once they've had time to settle. Quitting doesn't wait for it
+	if config.StartupDelayMs > 0 {
+		printInfof("Waiting %s before monitoring, startup_delay_ms is set\n", config.startupDelay())
+
+		if !sleepContext(ctx, config.startupDelay()) {
+			return
+		}
+	}
+
+	// Nothing is read from the registry, so there's a single made up hive to
+	// watch and nothing from a previous run to compare against
+	if simulateOption {
+		printWarning("Simulating proxy changes, the registry isn't being monitored")
+		writeLogEntry(logFile, SIMULATED_PREFIX+"simulating proxy changes, the registry isn't being monitored")
+
+		state := newWatchState(string(proxymon.HIVE_HKCU))
+		watchProxySettings(ctx, newSimulatedProxyReader(), state, SIMULATED_PREFIX, true, logFile, config)
+		return
+	}
+
+	// Not part of the wait group, the monitor only keeps running for as long
+	// as the proxy settings are being watched
+	if len(config.WatchedValues) > 0 {
+		startWorker(func() { watchConfiguredValues(ctx, logFile, config) })
+	}
+
+	if config.WinHTTPProxy {
+		startWorker(func() { watchWinHTTPProxy(ctx, logFile, config) })
+	}
+
+	// Settings from before the monitor was last stopped, if there are any
+	persisted := loadPersistedState(config)
+
+	// Profiles are only listed once, so a profile without the key, or one
+	// this user can't read, doesn't stop the others from being watched. It
+	// can still turn out there's nothing to watch at all
+	watchUsers := strings.EqualFold(config.RegistryHive, REGISTRY_HIVE_USERS)
+	if watchUsers && len(hives) == 0 {
+		printError("No loaded user profiles to monitor")
+		return
+	}
+
+	// Log lines only need to say which hive changed if there's more than one
+	tagLines := len(hives) > 1 || watchUsers
+	trayWatcherStarted := false
+
+	var wg sync.WaitGroup
+
+	for _, hive := range hives {
+		// The watchers are started in closures, which would otherwise all
+		// see the last hive
+		hive := hive
+
+		// Get a HANDLE for the key to monitor
+		key, err := proxymon.OpenKey(hive, proxymon.INTERNET_SETTINGS_KEY)
+
+		if err != nil {
+			// When watching several hives, the others can still be monitored.
+			// This mostly happens with HKLM, when the user doesn't have the
+			// permissions to read it
+			if len(hives) > 1 || watchUsers {
+				printWarningf("Failed to open %s registry key, not monitoring it: %v\n", string(hive), err)
+				continue
+			}
+
+			printError("Error opening registry key", err)
+			return
+		}
+
+		prefix := ""
+		if tagLines {
+			prefix = "[" + hiveDisplayName(hive) + "] "
+		}
+
+		// The auto-detect toggle and the proxies of dial-up and VPN
+		// connections live in a different key, and the PAC file isn't in the
+		// registry at all, so they're checked on their own. So is the bypass
+		// list, which rarely changes along with the proxy
+		startWorker(func() { watchAutoDetect(ctx, hive, prefix, logFile) })
+		startWorker(func() { watchConnections(ctx, hive, prefix, logFile) })
+		startWorker(func() { watchPacFile(ctx, hive, prefix, logFile) })
+		startWorker(func() { watchProxyOverride(ctx, hive, prefix, logFile) })
+
+		// Only one of the watchers updates the tray, otherwise the tray would
+		// flip between the states of each hive
+		notifyTray := !trayWatcherStarted
+		trayWatcherStarted = true
+
+		// Track the last known proxy enabled and proxy server states
+		state := newWatchState(string(hive))
+		saved, ok := persisted[string(hive)]
+		if ok {
+			state.restore(saved)
+		}
+
+		reader := newRegistryProxyReader(hive, key)
+
+		wg.Add(1)
+		startWorker(func() {
+			defer wg.Done()
+
+			watchProxySettings(ctx, reader, state, prefix, notifyTray, logFile, config)
+		})
+	}
+
+	wg.Wait()
+}
+
+// Checks a single hive's proxy settings for changes, until the context is
+// cancelled or reading them has failed too many times in a row. The polling
+// is left to a proxymon.Watcher, which reopens the reader when reading
+// fails. Every log line is prefixed with the given prefix
+func watchProxySettings(ctx context.Context, reader proxyReader, state *watchState, prefix string, notifyTray bool, logFile *monitorLog, config Config) {
+	defer reader.Close()
+
+	// Both the watcher and the debouncing below read the settings, which
+	// have to be read as a pair
+	var readMutex sync.Mutex
+
+	// Values that were last read with the wrong type, so the warning is only
+	// logged once rather than on every poll
+	wrongType := map[string]bool{}
+
+	// A nested function that warns about a value of the wrong type, the
+	// first time it's read that way. Something tampered with the value or
+	// the registry is corrupt, either way it's not worth stopping over
+	var warnWrongType = func(name string, fallback string) {
+		if wrongType[name] {
+			return
+		}
+		wrongType[name] = true
+
+		message := prefix + name + " has unexpected type, treating it as " + fallback
+		printWarning(message)
+		writeLogEntry(logFile, message)
+	}
+
+	// A nested function that reads both proxy settings from the reader.
+	// Errors have already been reported when it returns them
+	var readSettings = func() (uint64, string, error) {
+		readMutex.Lock()
+		defer readMutex.Unlock()
+
+		// Read the ProxyEnable setting
+		proxyEnable, err := reader.ProxyEnable()
+		if errors.Is(err, proxymon.ErrUnexpectedType) {
+			warnWrongType("ProxyEnable", "off")
+			proxyEnable = 0
+		} else if err != nil {
+			printError(prefix+"Failed to read ProxyEnable:", err)
+			controller.ReportError(state.hive, prefix+"failed to read ProxyEnable: "+err.Error())
+			return 0, "", err
+		}
+
+		// Warn again if it goes wrong again later
+		if !errors.Is(err, proxymon.ErrUnexpectedType) {
+			wrongType["ProxyEnable"] = false
+		}
+
+		// The watcher only knows on and off, so any other value has to mean
+		// on here too, or the two would never agree
+		if proxyEnable != 0 {
+			proxyEnable = 1
+		}
+
+		// Read the IP address of the proxy. ProxyEnable will always exist in
+		// the registry, but there's a chance that the ProxyServer value isn't
+		// set yet. That's read as an empty server, the same as every other
+		// read, so it's compared against the last known state like any other
+		// value instead of changing that state behind the comparison's back
+		proxyServer, err := reader.ProxyServer()
+		if errors.Is(err, proxymon.ErrNotExist) {
+			proxyServer = ""
+		} else if errors.Is(err, proxymon.ErrUnexpectedType) {
+			warnWrongType("ProxyServer", "empty")
+			proxyServer = ""
+		} else if err != nil {
+			printError(prefix+"Failed to read ProxyServer:", err)
+			controller.ReportError(state.hive, prefix+"failed to read ProxyServer: "+err.Error())
+			return 0, "", err
+		}
+
+		if !errors.Is(err, proxymon.ErrUnexpectedType) {
+			wrongType["ProxyServer"] = false
+		}
+
+		return proxyEnable, proxyServer, nil
+	}
+
+	// A nested function that keeps reading the settings until they've stopped
+	// changing for the debounce window, so programs that rewrite the settings
+	// several times in a row only produce a single log line.
+	// Returns the settled settings and how many intermediate states were
+	// skipped over, or false in the case of errors or when shutting down
+	var settle = func(proxyEnable uint64, proxyServer string) (uint64, string, int, bool) {
+		window := config.debounceWindow()
+		intermediate := 0
+
+		settledAt := time.Now().Add(window)
+		giveUpAt := time.Now().Add(window * DEBOUNCE_MAX_WINDOWS)
+
+		for time.Now().Before(settledAt) && time.Now().Before(giveUpAt) {
+			if !sleepContext(ctx, DEBOUNCE_POLL_INTERVAL) {
+				return 0, "", 0, false
+			}
+
+			newEnable, newServer, err := readSettings()
+			if err != nil {
+				return 0, "", 0, false
+			}
+
+			if config.Verbose {
+				printInfof("%sdebounce poll: ProxyEnable=%d ProxyServer=%q\n", prefix, newEnable, newServer)
+			}
+
+			if newEnable == proxyEnable && newServer == proxyServer {
+				continue
+			}
+
+			// The settings changed again, so the previous state was only an
+			// intermediate one. Start waiting from the beginning
+			intermediate++
+			proxyEnable = newEnable
+			proxyServer = newServer
+			settledAt = time.Now().Add(window)
+		}
+
+		return proxyEnable, proxyServer, intermediate, true
+	}
+
+	// A nested function that keeps reading the settings for the minimum
+	// stable duration, to make sure a change isn't just a flicker.
+	// Returns false as the second value if the settings changed again in the
+	// meantime, in which case the change is ignored entirely, or false as the
+	// first value in the case of errors or when shutting down
+	var staysStable = func(proxyEnable uint64, proxyServer string) (bool, bool) {
+		stableAt := time.Now().Add(config.minStableDuration())
+
+		for time.Now().Before(stableAt) {
+			if !sleepContext(ctx, DEBOUNCE_POLL_INTERVAL) {
+				return false, false
+			}
+
+			newEnable, newServer, err := readSettings()
+			if err != nil {
+				return false, false
+			}
+
+			if newEnable != proxyEnable || newServer != proxyServer {
+				if config.Verbose {
+					printInfof("%signored flicker: ProxyEnable=%d ProxyServer=%q\n", prefix, proxyEnable, proxyServer)
+				}
+				return true, false
+			}
+		}
+
+		return true, true
+	}
+
+	// A nested function that logs a change the watcher has seen to the
+	// settings, once they've settled, unless they're the same as the last
+	// known ones after all
+	var handleChange = func(proxyEnable uint64, proxyServer string) {
+		// Only the very first check can find a change that happened while
+		// the monitor wasn't running
+		previousRun := state.takePreviousRun()
+
+		// Whatever the first check finds is the baseline, the checks after
+		// it find the changes
+		defer state.startCounting()
+
+		// If neither value has changed, then there's nothing to log, stop here
+		if !state.differs(proxyEnable, proxyServer) {
+			return
+		}
+
+		intermediate := 0
+		if config.DebounceMs > 0 {
+			var ok bool
+			proxyEnable, proxyServer, intermediate, ok = settle(proxyEnable, proxyServer)
+			if !ok {
+				return
+			}
+		}
+
+		// Unlike debouncing, a state that doesn't last long enough is never
+		// logged at all, and the next check starts over from whatever the
+		// settings are by then
+		if config.MinStableDurationMs > 0 {
+			ok, stable := staysStable(proxyEnable, proxyServer)
+			if !ok || !stable {
+				return
+			}
+		}
+
+		// The settings may have settled back on the last known state, in
+		// which case there's nothing to log either
+		changed, previousEnable, previousServer := state.update(proxyEnable, proxyServer)
+		if !changed {
+			return
+		}
+
+		savePersistedState(config)
+
+		kind := changeEventKind(previousEnable, proxyEnable)
+		severity := eventSeverity(kind)
+
+		// Never notify when the baseline was reset, that isn't something the
+		// user did. By default only turning the proxy on or off is severe
+		// enough for a notification
+		baselineReset := previousEnable == UNKNOWN_PROXY_ENABLE
+
+		// A server that isn't one of the expected_proxies could be malware
+		// or someone listening in, so it's alerted even at startup and
+		// takes the place of the regular notification
+		var unexpected []string
+		if proxyEnable != 0 {
+			unexpected = unexpectedProxies(proxyServer, config.ExpectedProxies)
+		}
+		alerted := len(unexpected) > 0 && config.AlertUnexpectedProxy
+		unexpectedList := sanitizeLogValue(strings.Join(unexpected, ", "), MAX_DISPLAYED_SERVER_LENGTH)
+
+		if alerted {
+			sendLimitedNotification(prefix+"UNEXPECTED proxy: "+unexpectedList, config.notificationInterval())
+		} else if config.Notifications && !baselineReset && meetsThreshold(severity, config.NotificationSeverity) {
+			notifyProxyChange(prefix, kind, proxyServer, config.notificationInterval())
+		}
+
+		// Read right away, so the values match the proxy change as closely
+		// as possible
+		contextValues := readContextValues(config.ContextValues)
+
+		if config.WebhookURL != "" && meetsThreshold(severity, config.WebhookSeverity) {
+			sendWebhook(config.WebhookURL, state.hive, proxyEnable != 0, proxyServer, contextValues, prefix, logFile)
+		}
+
+		proxyHistory.add(historyEntry{
+			Time:    time.Now(),
+			Hive:    state.hive,
+			Enabled: proxyEnable != 0,
+			Server:  proxyServer,
+			Event:   kind,
+		})
+		notifyTrayHistory()
+
+		if notifyTray {
+			notifyTrayProxy(proxyState{enabled: proxyEnable != 0, server: proxyServer})
+		}
+
+		// When Group Policy enforces the settings, the user's own ones don't
+		// matter, which is worth knowing when the proxy won't turn off
+		enforced := reader.Source() != ""
+
+		// Off messages shouldn't have any information after the 'off' part
+		message := prefix + "proxy off"
+		if enforced {
+			message += " " + POLICY_ENFORCED_NOTE
+		}
+
+		if proxyEnable != 0 {
+			// Log a normalized breakdown of the server, rather than the raw
+			// per-protocol string
+			servers := displayProxyServer(proxyServer)
+			message = prefix + "proxy on, " + servers
+			if enforced {
+				message = prefix + "proxy on " + POLICY_ENFORCED_NOTE + ": " + servers
+			}
+
+			// The proxy was already on, so only the server was swapped, like
+			// when a VPN switches proxies
+			wasOn := previousEnable != 0 && previousEnable != UNKNOWN_PROXY_ENABLE
+			if wasOn {
+				previousServers := displayProxyServer(previousServer)
+				message = prefix + "proxy server changed: " + previousServers + " -> " + servers
+				if enforced {
+					message = prefix + "proxy server changed " + POLICY_ENFORCED_NOTE + ": " + previousServers + " -> " + servers
+				}
+			}
+
+			// Knowing which network the proxy was turned on for is nice, but
+			// not necessary, so just leave it out if it can't be found
+			connection, err := activeConnectionName()
+			if err == nil {
+				message += " (connection: " + connection + ")"
+			}
+		}
+
+		if previousRun != nil {
+			message = prefix + "proxy changed while monitor was offline: " +
+				describeProxy(previousRun.ProxyEnable, previousRun.ProxyServer) + " -> " + describeProxy(proxyEnable, proxyServer)
+		}
+
+		if intermediate > 0 {
+			message += fmt.Sprintf(" (debounced %d intermediate changes)", intermediate)
+		}
+
+		// Checked for every change, so a stale self-change doesn't linger.
+		// Changes the monitor made itself are always marked, so they can't
+		// be mistaken for something else changing the proxy
+		self := takeSelfChange(state.hive, proxyEnable, proxyServer)
+		if config.LogChangeOrigin || self {
+			message += changeOrigin(self)
+		}
+
+		if len(contextValues) > 0 {
+			message += " [" + formatContextValues(config.ContextValues, contextValues) + "]"
+		}
+
+		// Written on a line of its own, after the change, so the change line
+		// reads the same as always. Logged whatever log_events says
+		warning := ""
+		if len(unexpected) > 0 {
+			warning = prefix + "WARNING unexpected proxy server, not in expected_proxies: " + unexpectedList
+		}
+
+		// Sent whatever log_events says, a collector can filter on its own
+		if config.SyslogAddr != "" {
+			sendSyslog(config.SyslogAddr, kind, state.hive, proxyEnable != 0, proxyServer, unexpected, message, prefix, logFile)
+		}
+
+		// The change has still been tracked above, it's only left out of the
+		// log if the user doesn't care about this kind of change
+		if config.logsEvent(kind) {
+			writeLogEntry(logFile, message)
+			writeSeverityEvent(config, severity, message)
+			printEvent(kind, time.Now().Format(config.timestampLayout())+" "+message)
+		}
+
+		if warning != "" {
+			writeLogEntry(logFile, warning)
+			writeWarningEvent(warning)
+			printWarning(time.Now().Format(config.timestampLayout()), warning)
+		}
+
+		// Changes are rare, so it's worth making sure each one actually makes
+		// it to the disk, even if the machine loses power right after
+		if config.SyncLog {
+			syncLogFile(logFile)
+		}
+	}
+
+	// Adaptive polling speeds up from here when changes are seen
+	interval := config.pollInterval()
+
+	watcher := proxymon.NewWatcher(proxymon.Hive(state.hive))
+	watcher.Reader = &watchedProxyReader{read: readSettings, reopen: reader.Reopen, state: state, prefix: prefix, source: reader.Source}
+	watcher.Paused = func() bool { return !controller.Enabled() }
+
+	// The jitter is only added to the wait, so adaptive polling keeps
+	// working from the configured intervals. Picks up a reloaded config
+	watcher.NextInterval = func(changed bool) time.Duration {
+		config := currentConfig()
+		interval = config.nextPollInterval(interval, changed)
+		return config.withJitter(interval)
+	}
+
+	events, err := watcher.Start(ctx)
+	if err != nil {
+		printError(prefix+"Failed to start watching the registry:", err)
+		return
+	}
+
+	state.mutex.Lock()
+	state.watcher = watcher
+	state.mutex.Unlock()
+
+	for event := range events {
+		// Picks up changes from a reloaded config
+		config = currentConfig()
+
+		proxyEnable := uint64(0)
+		if event.Enabled {
+			proxyEnable = 1
+		}
+
+		handleChange(proxyEnable, event.Server)
+
+		// A flicker, a failed read while debouncing or settings that settled
+		// on something else leave the last known settings behind the
+		// watcher's, which would never send them again if they stay put
+		if state.differs(proxyEnable, event.Server) {
+			watcher.Reset()
+		}
+	}
+
+	// Shutting down also closes the events, which isn't a failed read
+	if ctx.Err() != nil {
+		return
+	}
+
+	printErrorf("%sFailed to read the registry %d times in a row, no longer monitoring\n", prefix, proxymon.MAX_READ_FAILURES)
+
+	state.mutex.Lock()
+	state.failed = true
+	state.mutex.Unlock()
+}
+
+// Hands the settings of a watched hive to its proxymon.Watcher, reporting
+// every failed read like the rest of the monitor does
+type watchedProxyReader struct {
+	read   func() (uint64, string, error)
+	reopen func() error
+	source func() string
+	state  *watchState
+	prefix string
+}
+
+func (r *watchedProxyReader) ReadState() (proxymon.State, error) {
+	proxyEnable, proxyServer, err := r.read()
+	if err != nil {
+		return proxymon.State{}, err
+	}
+
+	// Reading works again, so an earlier error no longer matters
+	controller.ClearError(r.state.hive)
+
+	if currentConfig().Verbose {
+		source := r.source()
+		if source == "" {
+			source = "user settings"
+		}
+
+		printInfof("%spoll: ProxyEnable=%d ProxyServer=%q source=%s changed=%t\n", r.prefix, proxyEnable, proxyServer, source, r.state.differs(proxyEnable, proxyServer))
+	}
+
+	return proxymon.State{Hive: proxymon.Hive(r.state.hive), Enabled: proxyEnable != 0, Server: proxyServer}, nil
+}
+
+// Starts the reader over, in case the old key handle went bad
+func (r *watchedProxyReader) Reopen() error {
+	err := r.reopen()
+	if err != nil {
+		printError(r.prefix+"Failed to reopen registry key:", err)
+		controller.ReportError(r.state.hive, r.prefix+"failed to reopen registry key: "+err.Error())
+	}
+
+	return err
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// A single read of the proxy settings by a scriptedProxyReader
+type scriptedRead struct {
+	proxyEnable    uint64
+	proxyEnableErr error
+	proxyServer    string
+	proxyServerErr error
+}
+
+// Hands out the scripted settings one read at a time, then keeps repeating
+// the last one
+type scriptedProxyReader struct {
+	mutex   sync.Mutex
+	script  []scriptedRead
+	reads   int
+	current scriptedRead
+}
+
+func (r *scriptedProxyReader) ProxyEnable() (uint64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.current = r.script[min(r.reads, len(r.script)-1)]
+	r.reads++
+	return r.current.proxyEnable, r.current.proxyEnableErr
+}
+
+func (r *scriptedProxyReader) ProxyServer() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.current.proxyServer, r.current.proxyServerErr
+}
+
+func (r *scriptedProxyReader) readCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.reads
+}
+
+func (r *scriptedProxyReader) Source() string { return "" }
+func (r *scriptedProxyReader) Reopen() error  { return nil }
+func (r *scriptedProxyReader) Close() error   { return nil }
+
+// Returns whatever settings the test last set, however often it's read
+type settableProxyReader struct {
+	mutex       sync.Mutex
+	proxyEnable uint64
+	proxyServer string
+}
+
+func (r *settableProxyReader) set(proxyEnable uint64, proxyServer string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.proxyEnable = proxyEnable
+	r.proxyServer = proxyServer
+}
+
+func (r *settableProxyReader) ProxyEnable() (uint64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.proxyEnable, nil
+}
+
+func (r *settableProxyReader) ProxyServer() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.proxyServer, nil
+}
+
+func (r *settableProxyReader) Source() string { return "" }
+func (r *settableProxyReader) Reopen() error  { return nil }
+func (r *settableProxyReader) Close() error   { return nil }
+
+// A config that polls every few milliseconds and only writes to the log, in
+// a directory of its own
+func testWatcherConfig(t *testing.T) Config {
+	config := defaultConfig()
+	config.LogPath = filepath.Join(t.TempDir(), LOG_FILE)
+	config.PollIntervalMs = 5
+	config.PollIntervalMinMs = 5
+	config.PollIntervalMaxMs = 5
+	config.PollJitterPercent = 0
+	config.DebounceMs = 0
+	config.Notifications = false
+	config.AlertUnexpectedProxy = false
+	config.SyncLog = false
+
+	return config
+}
+
+// Runs a watcher over the reader for as long as drive takes, or until the
+// watcher stopped by itself. Returns the messages it logged, without their
+// timestamps, and the watcher's state
+func runWatcher(t *testing.T, config Config, reader proxyReader, drive func(done <-chan struct{})) ([]string, *watchState) {
+	t.Helper()
+
+	previousConfig := currentConfig()
+	setCurrentConfig(config)
+	t.Cleanup(func() { setCurrentConfig(previousConfig) })
+
+	logFile, err := openMonitorLog(config)
+	if err != nil {
+		t.Fatalf("Failed to open the log: %v", err)
+	}
+
+	// A watcher that gave up would keep the controller from starting in the
+	// tests after this one
+	watchStatesMutex.Lock()
+	previousStates := watchStates
+	watchStatesMutex.Unlock()
+
+	t.Cleanup(func() {
+		watchStatesMutex.Lock()
+		watchStates = previousStates
+		watchStatesMutex.Unlock()
+	})
+
+	state := newWatchState("HKCU")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchProxySettings(ctx, reader, state, "", false, logFile, config)
+	}()
+
+	drive(done)
+
+	cancel()
+	<-done
+	logFile.close()
+
+	return readLogMessages(t, config.LogPath), state
+}
+
+// Returns the messages in the log file, without their timestamps
+func readLogMessages(t *testing.T, path string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the log: %v", err)
+	}
+
+	messages := []string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+
+		_, message, _ := strings.Cut(line, "\t")
+		messages = append(messages, message)
+	}
+
+	return messages
+}
+
+// Runs a watcher over the scripted reads until they've all been read, and
+// the last one long enough for the watcher to give up if it fails
+func runScriptedWatcher(t *testing.T, config Config, script []scriptedRead) ([]string, *watchState) {
+	t.Helper()
+
+	reader := &scriptedProxyReader{script: script}
+
+	return runWatcher(t, config, reader, func(done <-chan struct{}) {
+		deadline := time.Now().Add(5 * time.Second)
+		for reader.readCount() < len(script)+proxymon.MAX_READ_FAILURES+1 && time.Now().Before(deadline) {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	})
+}
+
+func TestWatchProxySettingsLogsChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []scriptedRead
+		want   []string
+	}{
+		{
+			name: "off to on",
+			script: []scriptedRead{
+				{proxyEnable: 0},
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+			},
+			want: []string{"proxy on, 10.0.0.1:8080"},
+		},
+		{
+			name: "on to server change",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnable: 1, proxyServer: "http=10.0.0.2:80;https=10.0.0.2:443"},
+			},
+			want: []string{
+				"proxy on, 10.0.0.1:8080",
+				"proxy server changed: 10.0.0.1:8080 -> http=10.0.0.2:80, https=10.0.0.2:443",
+			},
+		},
+		{
+			name: "on and off again",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnable: 0, proxyServer: "10.0.0.1:8080"},
+			},
+			want: []string{"proxy on, 10.0.0.1:8080", "proxy off"},
+		},
+		{
+			name: "failed read in between",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnableErr: errors.New("access denied")},
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnable: 1, proxyServer: "10.0.0.5:3128"},
+			},
+			want: []string{
+				"proxy on, 10.0.0.1:8080",
+				"proxy server changed: 10.0.0.1:8080 -> 10.0.0.5:3128",
+			},
+		},
+		{
+			name: "failed ProxyServer read",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnable: 0, proxyServerErr: errors.New("access denied")},
+				{proxyEnable: 0, proxyServer: "10.0.0.1:8080"},
+			},
+			want: []string{"proxy on, 10.0.0.1:8080", "proxy off"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, state := runScriptedWatcher(t, testWatcherConfig(t), test.script)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("logged %q, want %q", got, test.want)
+			}
+
+			if state.failed {
+				t.Error("watcher stopped after a single failed read")
+			}
+		})
+	}
+}
+
+func TestWatchProxySettingsGivesUpOnErrors(t *testing.T) {
+	script := []scriptedRead{
+		{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+		{proxyEnableErr: errors.New("the handle is invalid")},
+	}
+
+	got, state := runScriptedWatcher(t, testWatcherConfig(t), script)
+
+	want := []string{"proxy on, 10.0.0.1:8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+
+	if !state.failed {
+		t.Error("watcher kept going after reading kept failing")
+	}
+}
+
+func TestWatchProxySettingsMinStableDuration(t *testing.T) {
+	const MIN_STABLE = 200 * time.Millisecond
+
+	tests := []struct {
+		name string
+
+		// How long the proxy is off for before it's turned back on, 0 to
+		// leave it off
+		offFor time.Duration
+		want   []string
+	}{
+		{
+			name:   "flicker that reverts",
+			offFor: 20 * time.Millisecond,
+			want:   []string{"proxy on, 10.0.0.1:8080"},
+		},
+		{
+			name:   "change that persists",
+			offFor: 0,
+			want:   []string{"proxy on, 10.0.0.1:8080", "proxy off"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testWatcherConfig(t)
+			config.MinStableDurationMs = int(MIN_STABLE / time.Millisecond)
+
+			reader := &settableProxyReader{}
+			reader.set(1, "10.0.0.1:8080")
+
+			got, state := runWatcher(t, config, reader, func(done <-chan struct{}) {
+				// Long enough for the first state to count as stable
+				time.Sleep(2 * MIN_STABLE)
+
+				reader.set(0, "10.0.0.1:8080")
+				if test.offFor > 0 {
+					time.Sleep(test.offFor)
+					reader.set(1, "10.0.0.1:8080")
+				}
+
+				time.Sleep(2 * MIN_STABLE)
+			})
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("logged %q, want %q", got, test.want)
+			}
+
+			if state.failed {
+				t.Error("watcher stopped without a failed read")
+			}
+		})
+	}
+}
+
+func TestWatchProxySettingsEnabledWithoutServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []scriptedRead
+		want   []string
+	}{
+		{
+			name: "from the start",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+			},
+			want: []string{"proxy on, " + NO_PROXY_SERVER},
+		},
+		{
+			name: "turned on later",
+			script: []scriptedRead{
+				{proxyEnable: 0, proxyServerErr: proxymon.ErrNotExist},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+			},
+			want: []string{"proxy on, " + NO_PROXY_SERVER},
+		},
+		{
+			name: "same as an empty server",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+				{proxyEnable: 1, proxyServer: ""},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+			},
+			want: []string{"proxy on, " + NO_PROXY_SERVER},
+		},
+		{
+			name: "server set afterwards",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrNotExist},
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+			},
+			want: []string{
+				"proxy on, " + NO_PROXY_SERVER,
+				"proxy server changed: " + NO_PROXY_SERVER + " -> 10.0.0.1:8080",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, state := runScriptedWatcher(t, testWatcherConfig(t), test.script)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("logged %q, want %q", got, test.want)
+			}
+
+			if state.failed {
+				t.Error("watcher stopped over a missing ProxyServer")
+			}
+		})
+	}
+}
+
+func TestWatchProxySettingsSanitizesServer(t *testing.T) {
+	long := strings.Repeat("a", MAX_DISPLAYED_SERVER_LENGTH+100) + ":8080"
+
+	tests := []struct {
+		name   string
+		server string
+		want   []string
+	}{
+		{
+			name:   "injected line",
+			server: "http=10.0.0.1:80\nWed Oct 14 06:53:10 2026\tproxy off",
+			want:   []string{`proxy on, http=10.0.0.1:80\nWed Oct 14 06:53:10 2026\tproxy off`},
+		},
+		{
+			name:   "control characters",
+			server: "10.0.0.1:8080\r\x1b[2K\x00",
+			want:   []string{`proxy on, 10.0.0.1:8080\r\x1b[2k\x00`},
+		},
+		{
+			name:   "too long",
+			server: long,
+			want:   []string{"proxy on, " + long[:MAX_DISPLAYED_SERVER_LENGTH] + "…"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testWatcherConfig(t)
+			script := []scriptedRead{{proxyEnable: 1, proxyServer: test.server}}
+
+			got, _ := runScriptedWatcher(t, config, script)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("logged %q, want %q", got, test.want)
+			}
+
+			// Every line is a timestamp and a message, nothing else
+			data, err := os.ReadFile(config.LogPath)
+			if err != nil {
+				t.Fatalf("Failed to read the log: %v", err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("log has %d lines, want 1: %q", len(lines), data)
+			}
+
+			if strings.Count(lines[0], "\t") != 1 {
+				t.Errorf("log line has %d tabs, want 1: %q", strings.Count(lines[0], "\t"), lines[0])
+			}
+		})
+	}
+}
+
+func TestWatchProxySettingsUnexpectedType(t *testing.T) {
+	const ENABLE_WARNING = "ProxyEnable has unexpected type, treating it as off"
+	const SERVER_WARNING = "ProxyServer has unexpected type, treating it as empty"
+
+	tests := []struct {
+		name   string
+		script []scriptedRead
+		want   []string
+	}{
+		{
+			name: "ProxyEnable from the start",
+			script: []scriptedRead{
+				{proxyEnableErr: proxymon.ErrUnexpectedType, proxyServer: "10.0.0.1:8080"},
+				{proxyEnableErr: proxymon.ErrUnexpectedType, proxyServer: "10.0.0.1:8080"},
+				{proxyEnableErr: proxymon.ErrUnexpectedType, proxyServer: "10.0.0.1:8080"},
+			},
+			want: []string{ENABLE_WARNING, "proxy off"},
+		},
+		{
+			name: "ProxyEnable later on",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnableErr: proxymon.ErrUnexpectedType, proxyServer: "10.0.0.1:8080"},
+				{proxyEnableErr: proxymon.ErrUnexpectedType, proxyServer: "10.0.0.1:8080"},
+			},
+			want: []string{"proxy on, 10.0.0.1:8080", ENABLE_WARNING, "proxy off"},
+		},
+		{
+			name: "ProxyEnable fixed and broken again",
+			script: []scriptedRead{
+				{proxyEnableErr: proxymon.ErrUnexpectedType, proxyServer: "10.0.0.1:8080"},
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnableErr: proxymon.ErrUnexpectedType, proxyServer: "10.0.0.1:8080"},
+			},
+			want: []string{ENABLE_WARNING, "proxy off", "proxy on, 10.0.0.1:8080", ENABLE_WARNING, "proxy off"},
+		},
+		{
+			name: "ProxyServer",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrUnexpectedType},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrUnexpectedType},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrUnexpectedType},
+			},
+			want: []string{SERVER_WARNING, "proxy on, " + NO_PROXY_SERVER},
+		},
+		{
+			name: "ProxyServer later on",
+			script: []scriptedRead{
+				{proxyEnable: 1, proxyServer: "10.0.0.1:8080"},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrUnexpectedType},
+				{proxyEnable: 1, proxyServerErr: proxymon.ErrUnexpectedType},
+			},
+			want: []string{
+				"proxy on, 10.0.0.1:8080",
+				SERVER_WARNING,
+				"proxy server changed: 10.0.0.1:8080 -> " + NO_PROXY_SERVER,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, state := runScriptedWatcher(t, testWatcherConfig(t), test.script)
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("logged %q, want %q", got, test.want)
+			}
+
+			if state.failed {
+				t.Error("watcher stopped over a value of the wrong type")
+			}
+		})
+	}
+}
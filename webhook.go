@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// How long a single webhook request can take before it's abandoned
+const WEBHOOK_TIMEOUT = 5 * time.Second
+
+// How long to wait before retrying a failed webhook request
+const WEBHOOK_RETRY_DELAY = 2 * time.Second
+
+// Client used for every webhook request
+var webhookClient = &http.Client{Timeout: WEBHOOK_TIMEOUT}
+
+// Body of the webhook request sent for every proxy change
+type webhookPayload struct {
+	Event        string    `json:"event"`
+	Timestamp    time.Time `json:"timestamp"`
+	Hive         string    `json:"hive"`
+	ProxyEnabled bool      `json:"proxy_enabled"`
+	ProxyServer  string    `json:"proxy_server"`
+
+	// ProxyServer exactly as it was in the registry, before normalizing it
+	Raw string `json:"raw"`
+
+	// Context values from the config, left out if there aren't any
+	Context map[string]string `json:"context,omitempty"`
+}
+
+// Checks that the webhook URL from the config can actually be posted to
+func validateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme: %s", parsed.Scheme)
+	}
+
+	if parsed.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+
+	return nil
+}
+
+// Posts a proxy change to the webhook in the background, retrying once if it
+// fails. Failures are only logged, a broken webhook never stops the monitor
+func sendWebhook(webhookURL string, hive string, proxyOn bool, proxyServer string, contextValues map[string]string, prefix string, logFile *monitorLog) {
+	event := "proxy_off"
+	if proxyOn {
+		event = "proxy_on"
+	}
+
+	payload := webhookPayload{
+		Event:        event,
+		Timestamp:    time.Now(),
+		Hive:         hive,
+		ProxyEnabled: proxyOn,
+		ProxyServer:  normalizeProxyServer(proxyServer),
+		Raw:          proxyServer,
+		Context:      contextValues,
+	}
+
+	go func() {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			printError("Failed to encode webhook payload:", err)
+			return
+		}
+
+		err = postWebhook(webhookURL, body)
+		if err == nil {
+			return
+		}
+
+		time.Sleep(WEBHOOK_RETRY_DELAY)
+
+		err = postWebhook(webhookURL, body)
+		if err != nil {
+			printError(prefix+"Failed to send webhook:", err)
+			writeLogEntry(logFile, prefix+"webhook failed: "+err.Error())
+		}
+	}()
+}
+
+// Sends a single webhook request
+func postWebhook(webhookURL string, body []byte) error {
+	response, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+
+	return nil
+}
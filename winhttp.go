@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/andero-magi/proxy-monitor/proxymon"
+)
+
+// Key the WinHTTP proxy set with `netsh winhttp set proxy` is stored in
+const WINHTTP_CONNECTIONS_KEY = `SOFTWARE\Microsoft\Windows\CurrentVersion\Internet Settings\Connections`
+
+// Binary value holding the WinHTTP proxy settings
+const WINHTTP_SETTINGS_VALUE = "WinHttpSettings"
+
+// Flag in the WinHttpSettings blob that's set when a proxy server is used
+const WINHTTP_FLAG_PROXY uint32 = 0x2
+
+// WinHTTP proxy settings, decoded from the WinHttpSettings value
+type winHTTPProxy struct {
+	enabled bool
+	server  string
+	bypass  string
+}
+
+// Decodes the WinHttpSettings blob. It's a sequence of little-endian DWORDs:
+// the struct size, a change counter and the flags, followed by the proxy
+// server and the bypass list, each as a DWORD length and that many ASCII bytes
+func parseWinHTTPSettings(data []byte) (winHTTPProxy, error) {
+	offset := 0
+
+	readDword := func() (uint32, error) {
+		if offset+4 > len(data) {
+			return 0, errors.New("WinHttpSettings value is truncated")
+		}
+
+		value := binary.LittleEndian.Uint32(data[offset:])
+		offset += 4
+		return value, nil
+	}
+
+	readString := func() (string, error) {
+		length, err := readDword()
+		if err != nil {
+			return "", err
+		}
+
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return "", errors.New("WinHttpSettings value is truncated")
+		}
+
+		value := string(data[offset : offset+int(length)])
+		offset += int(length)
+		return value, nil
+	}
+
+	// Struct size and change counter, neither of which matter here
+	for i := 0; i < 2; i++ {
+		_, err := readDword()
+		if err != nil {
+			return winHTTPProxy{}, err
+		}
+	}
+
+	flags, err := readDword()
+	if err != nil {
+		return winHTTPProxy{}, err
+	}
+
+	server, err := readString()
+	if err != nil {
+		return winHTTPProxy{}, err
+	}
+
+	bypass, err := readString()
+	if err != nil {
+		return winHTTPProxy{}, err
+	}
+
+	return winHTTPProxy{
+		enabled: flags&WINHTTP_FLAG_PROXY != 0,
+		server:  server,
+		bypass:  bypass,
+	}, nil
+}
+
+// Reads the current WinHTTP proxy settings. When the value doesn't exist,
+// WinHTTP has never been configured and connects directly
+func readWinHTTPProxy() (winHTTPProxy, error) {
+	key, err := proxymon.OpenKey(proxymon.HIVE_HKLM, WINHTTP_CONNECTIONS_KEY)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return winHTTPProxy{}, nil
+	}
+	if err != nil {
+		return winHTTPProxy{}, err
+	}
+	defer key.Close()
+
+	data, _, err := key.GetBinaryValue(WINHTTP_SETTINGS_VALUE)
+	if errors.Is(err, proxymon.ErrNotExist) {
+		return winHTTPProxy{}, nil
+	}
+	if err != nil {
+		return winHTTPProxy{}, err
+	}
+
+	return parseWinHTTPSettings(data)
+}
+
+// Formats the settings as a log line, in the same style as the WinINET ones
+func (p winHTTPProxy) String() string {
+	if !p.enabled {
+		return "winhttp proxy off"
+	}
+
+	message := "winhttp proxy on, " + displayProxyServer(p.server)
+	if p.bypass != "" {
+		message += " (bypass: " + p.bypass + ")"
+	}
+
+	return message
+}
+
+// Checks the WinHTTP proxy settings for changes in a loop, logging them the
+// same way as the WinINET settings, starting with the current state
+func watchWinHTTPProxy(ctx context.Context, logFile *monitorLog, config Config) {
+	var last winHTTPProxy
+	hasBaseline := false
+
+	for {
+		config = currentConfig()
+
+		if controller.Enabled() {
+			current, err := readWinHTTPProxy()
+
+			if err != nil {
+				printError("Failed to read WinHTTP proxy settings:", err)
+			} else if !hasBaseline || current != last {
+				message := current.String()
+				writeLogEntry(logFile, message)
+				writeSeverityEvent(config, SEVERITY_INFO, message)
+
+				last = current
+				hasBaseline = true
+			}
+		}
+
+		if !sleepContext(ctx, config.pollInterval()) {
+			return
+		}
+	}
+}